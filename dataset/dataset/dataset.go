@@ -4,6 +4,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"github.com/atlasmir/golsmvdb/lsmvdb"
 	"github.com/atlasmir/golsmvdb/lsmvdb/db"
 	"golang.org/x/exp/slices"
 	"io"
@@ -298,14 +299,16 @@ func parseInstruction(row []string, clock *db.ValidTime) (inst instruction) {
 	return
 }
 
-// executeInstructions reads the instructions from the file and apply the instructions to a sample database.
+// executeInstructions reads the instructions from the file and apply the instructions to the empty database returned by
+// newEngine, whose queries wait deadline for their final results or complete once they reach the correctness threshold.
 // It scans the instruction file for two times:
-//   - the first round: executes all the Put() and Get() instructions following the arrival time order.
+//   - the first round: executes all the Put() and Get() instructions following the arrival time order. Each Get()
+//     instruction issues a query to the database, which refines its results upon the following Put() instructions.
 //   - the second round: executes all the Get() instructions based on the already-filled database. It is assumed that
 //     there is no temporal incorrect query results in this round.
 //
 // It returns the statistics of the execution.
-func executeInstructions(filename string, deadline db.ValidTime, correctness float64, sensors map[int][]int) (stats map[string]float64) {
+func executeInstructions(newEngine func(deadline db.ValidTime, correctness float64) lsmvdb.Engine, filename string, deadline db.ValidTime, correctness float64, sensors map[int][]int) (stats map[string]float64) {
 	//sensors := readSensorProperties("input/sensors.json")
 	file, err := os.Open(filename) // open as read-only
 	if err != nil {
@@ -320,14 +323,21 @@ func executeInstructions(filename string, deadline db.ValidTime, correctness flo
 
 	// Initialize the database
 	clock := db.ValidTime(0)
-	sampleDB := db.NewDB("", db.ValidTime(clock))
-	queryPool := db.NewQueryPool()
-	queryPool.SetSensors(sensors)
+	sampleDB := newEngine(deadline, correctness)
+	defer func(sampleDB lsmvdb.Engine) {
+		err := sampleDB.Close()
+		if err != nil {
+			log.Fatalln("failed to close database", err)
+		}
+	}(sampleDB)
+	sampleDB.SetSensors(sensors)
 	fmt.Printf("Deadline = %d\n", deadline)
 	fmt.Printf("Correctness Threshold = %f\n", correctness)
 
 	// Statistics
 	results1 := make(map[db.ValidTime]*queryResult, 0)
+	queries := make(map[db.ValidTime]*db.Query) // the query issued by each Get() instruction
+	pending := make([]*db.Query, 0)             // the queries whose results are not final yet
 	stats = map[string]float64{
 		"total_queries":        0,
 		"total_response_time":  0,
@@ -341,7 +351,6 @@ func executeInstructions(filename string, deadline db.ValidTime, correctness flo
 		"scan_count":           0, // number of times the query pool is scanned
 		"time_first_execution": 0,
 		"inconsistent_results": 0,
-		"update_average_time":  0, // the average time cost of updating a query in the query pool
 	}
 
 	// Read the csv file
@@ -370,75 +379,79 @@ func executeInstructions(filename string, deadline db.ValidTime, correctness flo
 			stats["total_queries"]++
 			requestedKeys := make([]db.Key, inst.numberOfKeys)
 			requestedKeys[0] = inst.key // the first requested key in the query
-			query := db.NewQuery(clock, inst.validTime, inst.numberOfKeys)
 			results1[clock] = newQueryResult()
 			for i, k := range inst.additionalKeys {
 				requestedKeys[i+1] = k
 			}
-			// iterate over the keys in a single query
 			timeStart := time.Now()
-			for _, k := range requestedKeys {
-				message, status, nextSequence, err := sampleDB.Get(k, inst.validTime)
-				if err != nil {
-					log.Fatalln("failed to get value from memtable", err)
-				}
-				prob := db.ProbTemporalCorrect(sensors[int(k)][0], sensors[int(k)][1], message.CreationTime(), inst.validTime)
-				query.NewResult(k, &message, status, nextSequence, prob)
-				if status == db.OK {
-					query.CompleteOneKey()
-				}
-				// append result for experiment statistics
-				results1[clock].update(k, message.SequenceNumber())
+			query, err := sampleDB.Query(requestedKeys, inst.validTime,
+				&db.QueryOptions{ArrivalTime: clock, Deadline: deadline, Correctness: correctness})
+			if err != nil {
+				log.Fatalln("failed to query database", err)
 			}
 			stats["time_first_execution"] += float64(time.Since(timeStart).Microseconds())
+			queries[clock] = query
+			// append result for experiment statistics
+			for _, k := range requestedKeys {
+				results1[clock].update(k, query.Result(k).Message().SequenceNumber())
+			}
 
-			if !query.AllKeysOK() && !query.MaybeCorrect(correctness) {
-				// query is not immediately satisfied. Add it to the query pool.
-				queryPool.Add(query)
-				query.SetPool(queryPool)
-			} else if query.AllKeysOK() {
-				// query is immediately satisfied
-				stats["ok_count"]++
+			select {
+			case <-query.Done():
+				switch query.Reason() {
+				case db.NonODV:
+					// query is immediately satisfied
+					stats["ok_count"]++
+				case db.MaybeCorrect:
+					// query immediately satisfies the correctness threshold
+					stats["ck_count"]++
+				}
+			default:
+				// query is not immediately satisfied. The database keeps it in its query pool.
+				pending = append(pending, query)
 			}
 
 		case Put: // insert
-			value := fmt.Sprintf("value: %d", inst.sequenceNumber)
-			err = sampleDB.Put(inst.key, inst.sequenceNumber, inst.validTime, value)
+			value := db.Int64Value(int64(inst.sequenceNumber))
+			// the write updates the queries in the query pool of the database
+			timeStart := time.Now()
+			err = sampleDB.PutValue(inst.key, inst.sequenceNumber, inst.validTime, clock, value)
 			if err != nil {
 				log.Fatalln("failed to insert", err)
 			}
-			timeStart := time.Now()
-			completedQueries, updatedQueries := queryPool.Update(
-				clock, inst.key,
-				db.NewMessage(inst.validTime, inst.sequenceNumber, value),
-				deadline, correctness)
 			stats["time_scan_query_pool"] += float64(time.Since(timeStart).Microseconds())
 			stats["scan_count"]++
-			for _, q := range completedQueries {
-				responseTime := float64(clock - q.ArrivalTime())
-				if responseTime > float64(deadline) {
-					stats["timeouts"]++
-					stats["total_response_time"] += float64(deadline)
-				} else {
-					stats["total_response_time"] += responseTime
-				}
-
-				result := results1[q.ArrivalTime()]
-				for k := range result.result {
-					result.update(k, q.Result(k).Message().SequenceNumber())
-				}
-			}
-			for _, q := range updatedQueries {
-				result := results1[q.ArrivalTime()]
-				for k := range result.result {
-					result.update(k, q.Result(k).Message().SequenceNumber())
+			remaining := pending[:0]
+			for _, q := range pending {
+				select {
+				case <-q.Done():
+					switch q.Reason() {
+					case db.MaybeCorrect:
+						stats["ck_count"]++
+					case db.Timeout:
+						stats["timeout_count"]++
+					}
+					responseTime := float64(q.CompletedAt() - q.ArrivalTime())
+					if responseTime > float64(q.Deadline()) {
+						stats["total_response_time"] += float64(q.Deadline())
+					} else {
+						stats["total_response_time"] += responseTime
+					}
+				default:
+					remaining = append(remaining, q)
 				}
 			}
+			pending = remaining
 		default:
 			log.Fatalln("unknown operation", inst.op)
 		}
 	}
-	stats["update_average_time"] = queryPool.UpdateAverageTime()
+	// the queries hold their latest results
+	for arrivalTime, result := range results1 {
+		for k := range result.result {
+			result.update(k, queries[arrivalTime].Result(k).Message().SequenceNumber())
+		}
+	}
 
 	/*
 	 * Second round of execution
@@ -3,7 +3,9 @@ package dataset
 import (
 	"encoding/csv"
 	"fmt"
+	"github.com/atlasmir/golsmvdb/lsmvdb"
 	"github.com/atlasmir/golsmvdb/lsmvdb/db"
+	"github.com/atlasmir/golsmvdb/lsmvdb/memdb"
 	"log"
 	"os"
 	"testing"
@@ -18,8 +20,18 @@ func TestReadSensorProperties(t *testing.T) {
 	fmt.Println(sensors)
 }
 
+// newSampleDB returns an empty in-memory database whose queries wait deadline for their final results, or complete
+// once they reach the correctness threshold.
+func newSampleDB(deadline db.ValidTime, correctness float64) lsmvdb.Engine {
+	sampleDB, err := db.Open("", &db.Options{QueryDeadline: deadline, QueryCorrectness: correctness})
+	if err != nil {
+		log.Fatalln("failed to create database", err)
+	}
+	return sampleDB
+}
+
 func TestExecuteInstructions(t *testing.T) {
-	stats := executeInstructions("input/instructions.txt", 2000, 0.0, readSensorProperties("input/sensors.json"))
+	stats := executeInstructions(newSampleDB, "input/instructions.txt", 2000, 0.0, readSensorProperties("input/sensors.json"))
 
 	fmt.Printf("Inconsistent Results = %f\n", stats["inconsistent_results"])
 	fmt.Printf("Total number of queries: %f\n", stats["total_queries"])
@@ -29,6 +41,26 @@ func TestExecuteInstructions(t *testing.T) {
 	fmt.Printf("Average time spent for query first exexution: %f micros\n", stats["time_first_execution"]/stats["total_queries"])
 }
 
+// TestCompareEngines runs the same instruction file against every backend.
+func TestCompareEngines(t *testing.T) {
+	engines := map[string]func(deadline db.ValidTime, correctness float64) lsmvdb.Engine{
+		"db": newSampleDB,
+		"memdb": func(deadline db.ValidTime, correctness float64) lsmvdb.Engine {
+			return memdb.New(&memdb.Options{QueryDeadline: deadline, QueryCorrectness: correctness})
+		},
+	}
+	sensors := readSensorProperties("input/sensors.json")
+	results := make(map[string]map[string]float64)
+	for name, newEngine := range engines {
+		results[name] = executeInstructions(newEngine, "input/instructions.txt", 2000, 0.0, sensors)
+	}
+	for _, stat := range []string{"total_queries", "ok_count", "inconsistent_results"} {
+		if results["db"][stat] != results["memdb"][stat] {
+			t.Fatalf("%s: db = %f, memdb = %f", stat, results["db"][stat], results["memdb"][stat])
+		}
+	}
+}
+
 func TestBackwardExecutionOption(t *testing.T) {
 	file, err := os.OpenFile("output/beo.txt", os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -50,14 +82,14 @@ func TestBackwardExecutionOption(t *testing.T) {
 	for _, dkForward := range []int{2000, 3000, 4000, 5000, 6000} {
 		for _, dk := range []int{2000, 2500, 3000, 3500, 4000, 4500, 5000} {
 			generateOfflineInstructions(3000, dkForward, 10)
-			stats := executeInstructions("input/instructions.txt", db.ValidTime(dk), 1.0, readSensorProperties("input/sensors.json"))
+			stats := executeInstructions(newSampleDB, "input/instructions.txt", db.ValidTime(dk), 1.0, readSensorProperties("input/sensors.json"))
 			record := []string{
 				fmt.Sprintf("%d", dkForward-1000), // assume mq = 1000
 				fmt.Sprintf("%d", dk),
 				fmt.Sprintf("%f", stats["total_queries"]),
 				fmt.Sprintf("%f", stats["ok_count"]),
 				fmt.Sprintf("%f", stats["inconsistent_results"]),
-				fmt.Sprintf("%f", stats["timeouts"]),
+				fmt.Sprintf("%f", stats["timeout_count"]),
 				fmt.Sprintf("%f", stats["total_response_time"]/stats["total_queries"]),
 			}
 			if err := write.Write(record); err != nil {
@@ -79,7 +111,7 @@ func BenchmarkQpESOverhead(b *testing.B) {
 		}
 	}(file)
 
-	header := []string{"keysPerQuery", "dk", "ck", "numberOfQueries", "firstExecutionTime", "scanTime", "scanCount", "averageUpdateTime"}
+	header := []string{"keysPerQuery", "dk", "ck", "numberOfQueries", "firstExecutionTime", "scanTime", "scanCount"}
 	writer := csv.NewWriter(file)
 	if err := writer.Write(header); err != nil {
 		log.Fatalln("error writing header to csv:", err)
@@ -90,7 +122,7 @@ func BenchmarkQpESOverhead(b *testing.B) {
 		generateOfflineInstructions(3000, 3000, k)
 		for d := 2000; d < 5001; d += 500 {
 			for c := 0.2; c < 1.1; c += 0.4 {
-				stats := executeInstructions("input/instructions.txt", db.ValidTime(d), c, readSensorProperties("input/sensors.json"))
+				stats := executeInstructions(newSampleDB, "input/instructions.txt", db.ValidTime(d), c, readSensorProperties("input/sensors.json"))
 				record := []string{
 					fmt.Sprintf("%d", k),
 					fmt.Sprintf("%d", d),
@@ -99,7 +131,6 @@ func BenchmarkQpESOverhead(b *testing.B) {
 					fmt.Sprintf("%f", stats["time_first_execution"]/stats["total_queries"]),
 					fmt.Sprintf("%f", stats["time_scan_query_pool"]/stats["scan_count"]),
 					fmt.Sprintf("%f", stats["scan_count"]),
-					fmt.Sprintf("%f", stats["update_average_time"]),
 				}
 				if err := writer.Write(record); err != nil {
 					log.Fatalln("error writing record to csv:", err)
@@ -0,0 +1,228 @@
+package db
+
+import "sort"
+
+// seqSpan is a range [first, last] of consecutive sequence numbers that have all been written to a key.
+type seqSpan struct {
+	first SequenceNumber
+	last  SequenceNumber
+}
+
+// seqSpans records which sequence numbers have been written to a key. The spans are sorted, disjoint and
+// not adjacent to each other.
+//
+// Tables keep the spans of their keys even after a compaction drops versions. This way, a version whose
+// immediate successor has been dropped is still known to be followed by it, and Get keeps reporting OK
+// instead of HOLE or ODV for it.
+type seqSpans []seqSpan
+
+func (s seqSpans) contains(seq SequenceNumber) bool {
+	// i is the first span ending at or after seq
+	i := sort.Search(len(s), func(i int) bool { return s[i].last >= seq })
+	return i < len(s) && s[i].first <= seq
+}
+
+// add returns the spans extended by seq.
+func (s seqSpans) add(seq SequenceNumber) seqSpans {
+	if n := len(s); n > 0 && s[n-1].first <= seq && seq <= s[n-1].last+1 {
+		// the common case of sequence numbers written in ascending order
+		if seq > s[n-1].last {
+			s[n-1].last = seq
+		}
+		return s
+	}
+	return s.union(seqSpans{{seq, seq}})
+}
+
+// union returns the spans covering the sequence numbers of both s and o.
+func (s seqSpans) union(o seqSpans) seqSpans {
+	all := make(seqSpans, 0, len(s)+len(o))
+	all = append(append(all, s...), o...)
+	sort.Slice(all, func(i, j int) bool { return all[i].first < all[j].first })
+	result := all[:0]
+	for _, span := range all {
+		if n := len(result); n > 0 && span.first <= result[n-1].last+1 {
+			if span.last > result[n-1].last {
+				result[n-1].last = span.last
+			}
+			continue
+		}
+		result = append(result, span)
+	}
+	return result
+}
+
+// spans returns the sequence spans of key recorded by the tables of the database.
+func (db *DB) spans(key Key) (spans seqSpans) {
+	for _, level := range db.levels {
+		for _, t := range level {
+			if s, ok := t.spans[key]; ok {
+				spans = spans.union(s)
+			}
+		}
+	}
+	return spans
+}
+
+// maybeCompact merges the tables of every level holding opts.LevelRuns tables into a single table
+// in the next level. The database is organized in tiers: level 0 receives flushed memtables, and every table
+// of level i+1 holds data older than any table of level i.
+func (db *DB) maybeCompact() error {
+	for i := 0; i < len(db.levels); i++ {
+		if len(db.levels[i]) < db.opts.LevelRuns {
+			continue
+		}
+		if i+1 == len(db.levels) {
+			db.levels = append(db.levels, nil)
+		}
+		inputs := db.levels[i]
+		number := db.nextFileNumber
+		db.nextFileNumber++
+		filename := db.fileName(number, "sst")
+		// the output is the bottom of the tiers if no older table holds versions shadowed by its tombstones
+		bottom := true
+		for _, level := range db.levels[i+1:] {
+			if len(level) > 0 {
+				bottom = false
+			}
+		}
+		horizon := func(key Key) ValidTime { return db.horizon(key, db.archiveTime) }
+		if err := majorCompact(inputs, filename, i+1, bottom, horizon, db.opts); err != nil {
+			return err
+		}
+		if err := db.hook("compaction: table written"); err != nil {
+			return err
+		}
+		t, err := openTable(filename, number)
+		if err != nil {
+			return err
+		}
+		db.levels[i+1] = append(db.levels[i+1], t)
+		db.levels[i] = nil
+		if err = db.writeManifest(); err != nil {
+			return err
+		}
+		if err = db.hook("compaction: manifest written"); err != nil {
+			return err
+		}
+		for _, input := range inputs {
+			input.Close()
+		}
+		if err = db.removeObsoleteFiles(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// majorCompact merges the tables inputs, ordered from oldest to newest, into a table file of the given level.
+// If two inputs hold the same version, the one from the newer input is kept. The versions of a key whose validity
+// ends at or before horizon(key) are dropped. Tombstones are kept until they are compacted into the bottom level,
+// where no older table is left to hold the versions they delete.
+//
+// If opts.MaxVersionsPerKey is set, only the newest versions of each key are kept by a compaction into the bottom
+// level. Its inputs hold the oldest versions of the database, so the versions dropped are the oldest of the key's
+// history. A compaction into an upper level keeps every version: trimming its inputs would drop versions from the
+// middle of the history, whose predecessors would then resolve against the spans as if they were still valid.
+// The sequence spans of the inputs are carried over, including those of dropped versions and tombstones.
+func majorCompact(inputs []*table, filename string, level int, bottom bool, horizon func(Key) ValidTime, opts *Options) (err error) {
+	w, err := newTableWriter(filename, opts.BlockSize, level)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			w.Abort()
+		}
+	}()
+
+	// iterators are ordered from newest to oldest, so that ties are won by the newest input
+	iterators := make([]*tableIterator, len(inputs))
+	valid := make([]bool, len(inputs))
+	for i := range inputs {
+		iterators[i] = inputs[len(inputs)-1-i].newIterator()
+		valid[i] = iterators[i].Next()
+	}
+	for _, t := range inputs {
+		for key, spans := range t.spans {
+			w.AddSpans(key, spans)
+		}
+	}
+
+	var key Key
+	var versions []Message
+	writeKey := func() error {
+		versions, _ = expire(versions, horizon(key))
+		// the number of the oldest versions to drop; tombstones do not count as versions
+		drop := 0
+		if bottom && opts.MaxVersionsPerKey > 0 {
+			drop = -opts.MaxVersionsPerKey
+			for _, version := range versions {
+				if version.kind != tombstoneKind {
+					drop++
+				}
+			}
+		}
+		for _, version := range versions {
+			if version.kind == tombstoneKind {
+				if bottom {
+					continue
+				}
+			} else if drop > 0 {
+				drop--
+				continue
+			}
+			if err := w.Add(key, version); err != nil {
+				return err
+			}
+		}
+		versions = versions[:0]
+		return nil
+	}
+	for {
+		min := -1
+		for i, it := range iterators {
+			if valid[i] && (min < 0 || compareEntries(it, iterators[min]) < 0) {
+				min = i
+			}
+		}
+		if min < 0 {
+			break
+		}
+		k, message := iterators[min].Key(), iterators[min].Message()
+		// skip the same version in older inputs
+		for i, it := range iterators {
+			if valid[i] && it.Key() == k && compareVersions(it.Message(), message) == 0 {
+				valid[i] = it.Next()
+			}
+		}
+		if k != key && len(versions) > 0 {
+			if err = writeKey(); err != nil {
+				return err
+			}
+		}
+		key = k
+		versions = append(versions, message)
+	}
+	for _, it := range iterators {
+		if err = it.Err(); err != nil {
+			return err
+		}
+	}
+	if err = writeKey(); err != nil {
+		return err
+	}
+	return w.Finish()
+}
+
+// compareEntries orders the current entries of two table iterators.
+func compareEntries(a, b *tableIterator) int {
+	switch {
+	case a.Key() < b.Key():
+		return -1
+	case a.Key() > b.Key():
+		return 1
+	default:
+		return compareVersions(a.Message(), b.Message())
+	}
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSeqSpans(t *testing.T) {
+	var spans seqSpans
+	for _, seq := range []SequenceNumber{1, 2, 3, 7, 5, 6, 10} {
+		spans = spans.add(seq)
+	}
+	if want := (seqSpans{{1, 3}, {5, 7}, {10, 10}}); !reflect.DeepEqual(spans, want) {
+		t.Fatalf("spans = %v, want %v", spans, want)
+	}
+	spans = spans.union(seqSpans{{4, 4}, {11, 20}})
+	if want := (seqSpans{{1, 7}, {10, 20}}); !reflect.DeepEqual(spans, want) {
+		t.Fatalf("spans = %v, want %v", spans, want)
+	}
+	for seq, want := range map[SequenceNumber]bool{0: false, 1: true, 7: true, 8: false, 9: false, 10: true, 20: true, 21: false} {
+		if spans.contains(seq) != want {
+			t.Fatalf("spans.contains(%d) = %t", seq, !want)
+		}
+	}
+}
+
+func TestDB_MajorCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 512, LevelRuns: 2}
+	memDB, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j < 100; j++ {
+		for k := 1; k < 10; k++ {
+			if j%7 == 0 {
+				continue
+			}
+			for _, db := range []*DB{memDB, diskDB} {
+				if err := db.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if len(diskDB.levels) < 3 {
+		t.Fatalf("expected at least 3 levels, got %d", len(diskDB.levels))
+	}
+	compareGets(t, memDB, diskDB)
+
+	if err := diskDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	compareGets(t, memDB, diskDB)
+}
+
+func TestDB_CompactionKeepsSequenceContinuity(t *testing.T) {
+	memDB, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 256, LevelRuns: 2, MaxVersionsPerKey: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	put := func(seq int) {
+		for _, db := range []*DB{memDB, diskDB} {
+			if err := db.Put(Key(1), SequenceNumber(seq), ValidTime(seq*10), fmt.Sprintf("value %d", seq)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for seq := 1; seq <= 60; seq++ {
+		if seq != 20 {
+			put(seq)
+		}
+	}
+	// flush the remaining versions, so that the late version stays in the memtable
+	if err := diskDB.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := diskDB.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every version kept by the compactions is classified as before
+	dropped := 0
+	for seq := 1; seq <= 60; seq++ {
+		message, _, _ := diskDB.lookup(Key(1), ValidTime(seq*10))
+		if message == nil || message.sequenceNumber != SequenceNumber(seq) {
+			dropped++
+			continue
+		}
+		_, s1, n1, _ := memDB.Get(Key(1), ValidTime(seq*10))
+		_, s2, n2, _ := diskDB.Get(Key(1), ValidTime(seq*10))
+		if s1 != s2 || n1 != n2 {
+			t.Fatalf("Get at version %d = %s %d, want %s %d", seq, s2, n2, s1, n1)
+		}
+	}
+	if dropped == 0 {
+		t.Fatal("no version has been dropped")
+	}
+
+	// a late version whose successor has been dropped is still followed by it
+	put(20)
+	message, status, nextSequence, err := diskDB.Get(Key(1), ValidTime(205))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.SequenceNumber() != 20 || status != OK || nextSequence != 21 {
+		t.Fatalf("Get(1, 205) = %s %s %d, want version 20 OK 21", message, status, nextSequence)
+	}
+}
+
+func TestDB_CompactionTrimsOldestVersions(t *testing.T) {
+	memDB, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 256, LevelRuns: 2, MaxVersionsPerKey: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	for seq := 1; seq <= 60; seq++ {
+		for _, db := range []*DB{memDB, diskDB} {
+			if err := db.Put(Key(1), SequenceNumber(seq), ValidTime(seq*10), fmt.Sprintf("value %d", seq)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// reads between the versions of the kept tables return the version valid at the time,
+	// and only the oldest versions are missing
+	trimmed := 0
+	for seq := 1; seq <= 60; seq++ {
+		time := ValidTime(seq*10 + 5)
+		m1, s1, n1, _ := memDB.Get(Key(1), time)
+		m2, s2, n2, err := diskDB.Get(Key(1), time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s2 == NOTFOUND && trimmed == seq-1 {
+			trimmed++
+			continue
+		}
+		if m1.SequenceNumber() != m2.SequenceNumber() || s1 != s2 || n1 != n2 {
+			t.Fatalf("Get(1, %d) = %d %s %d, want %d %s %d", time, m2.SequenceNumber(), s2, n2, m1.SequenceNumber(), s1, n1)
+		}
+	}
+	if trimmed == 0 {
+		t.Fatal("no version has been dropped")
+	}
+}
@@ -1,34 +1,432 @@
 package db
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DB is safe for concurrent use. Put and Get hold mu shared: versions of different keys are written in parallel,
+// and the memtable orders the writes of each key. Rotating the memtable, flushing and compacting hold mu exclusively.
 type DB struct {
+	mu sync.RWMutex
+	// keyLocks serialize the writes to the same key that depend on its stored versions
+	keyLocks [keyLockStripes]sync.Mutex
+
+	stats Stats // updated atomically
+
+	quarantineMu sync.Mutex
+	quarantined  map[Key]bool // the keys quarantined by the QuarantineOutOfOrder policy
+
+	retention map[Key]ValidTime // the retention of the keys overriding opts.Retention
+
 	// file path
 	path string
+	opts *Options
+	log  *walWriter // nil if the database is not backed by a directory
 
 	// core data structures
-	mem *Memtable
+	mem    *Memtable
+	imm    []*Memtable // archived memtables waiting for minorCompact, oldest first
+	levels [][]*table  // the tables of each level, oldest first
+
+	nextFileNumber uint64
+	archiveTime    ValidTime // the archive time of the latest flushed memtable
+
+	// crashHook, if set, is called at the points of flushes and compactions where a crash leaves
+	// intermediate files behind. An error returned by the hook aborts the operation.
+	crashHook func(point string) error
+
+	// queryMu guards queries. It is acquired before mu, since a query reads the versions it is issued for.
+	queryMu sync.Mutex
+	queries *QueryPool // the pending queries issued by Query
+	active  int64      // the number of pending queries, read by writes without queryMu
+	clock   ValidTime  // the latest creation or arrival time written, or passed to Advance
 
 	// helper fields for experiments
 	sensors map[int][]int // stores the sensor properties
 }
 
-func NewDB(path string, creationTime ValidTime) *DB {
-	return &DB{
-		path: path,
-		mem:  NewMemtable(0),
+// component is a part of the database holding versions, e.g., a memtable or a table.
+type component interface {
+	lookup(key Key, time ValidTime) (message, next *Message, err error)
+	versions(key Key) ([]Message, error)
+	keys() []Key
+}
+
+// Open opens the database stored under the directory path, creating it if necessary.
+// Writes are logged to a write-ahead log in that directory. Open rebuilds the state of the database
+// from its manifest and replays the logs that have not been flushed yet.
+// An empty path opens a purely in-memory database whose memtable is never flushed.
+// A nil opts selects the default options.
+func Open(path string, opts *Options) (*DB, error) {
+	db := &DB{
+		path:           path,
+		opts:           opts.withDefaults(),
+		mem:            NewMemtable(0),
+		nextFileNumber: 1,
+		queries:        NewQueryPool(),
+		quarantined:    make(map[Key]bool),
+		retention:      make(map[Key]ValidTime),
+	}
+	db.queries.SetOutOfOrderPolicy(db.opts.OutOfOrderPolicy)
+	db.queries.SetRetain(db.opts.RetainQueries)
+	if path == "" {
+		return db, nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	if err := db.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close releases the write-ahead log and the tables. The database must not be used afterwards.
+func (db *DB) Close() (err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.log != nil {
+		err = db.log.Close()
+	}
+	for _, level := range db.levels {
+		for _, t := range level {
+			if closeErr := t.Close(); err == nil {
+				err = closeErr
+			}
+		}
 	}
+	return err
 }
 
 func (db *DB) Put(key Key, sequenceNumber SequenceNumber, creationTime ValidTime, value string) (err error) {
-	// TODO: implement multiple components
-	err = db.mem.Put(key, sequenceNumber, creationTime, value)
+	return db.PutAt(key, sequenceNumber, creationTime, 0, value)
+}
+
+// PutAt is like Put, but records the time the version arrived at the database.
+// GetAsOf only sees the versions that arrived by the requested arrival time. Put records an arrival time of 0,
+// so its versions are visible to every such query.
+//
+// If opts.AssignSequenceNumbers is set, a sequence number of 0 asks the database to assign one, as AppendAt does.
+// A version whose sequence number contradicts its creation time is handled by opts.OutOfOrderPolicy.
+// Writing a stored version again is a no-op, while a different version with the sequence number of a stored one
+// fails with ConflictingVersion. Both are counted in Stats.
+func (db *DB) PutAt(key Key, sequenceNumber SequenceNumber, creationTime, arrivalTime ValidTime, value string) (err error) {
+	return db.PutValue(key, sequenceNumber, creationTime, arrivalTime, StringValue(value))
+}
+
+// PutValue is like PutAt, but stores a typed value.
+func (db *DB) PutValue(key Key, sequenceNumber SequenceNumber, creationTime, arrivalTime ValidTime, value Value) (err error) {
+	if sequenceNumber == 0 && db.opts.AssignSequenceNumbers {
+		_, err = db.appendValue(key, creationTime, arrivalTime, value)
+		return
+	}
+	lock := db.keyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	creationTime, duplicate, err := db.admit(key, sequenceNumber, creationTime, value)
+	if err != nil || duplicate {
+		return
+	}
+	return db.write(key, *NewValueMessage(creationTime, sequenceNumber, arrivalTime, value))
+}
+
+// write writes a record to the log and the memtable, and flushes the memtable once it is full.
+func (db *DB) write(key Key, message Message) (err error) {
+	// the log and the memtable must not be rotated between logging the version and inserting it
+	db.mu.RLock()
+	if db.log != nil {
+		if err = db.log.Append(key, message); err != nil {
+			db.mu.RUnlock()
+			return
+		}
+	}
+	err = db.mem.add(key, message)
+	full := db.log != nil && db.memtableFull()
+	db.mu.RUnlock()
+	if err != nil {
+		return
+	}
+	// the pending queries are refined by new versions, not by the corrections of stored ones
+	if message.kind == valueKind {
+		db.updateQueries(key, message)
+	}
+	if !full {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// another writer may have rotated the memtable in the meantime
+	if db.memtableFull() {
+		if err = db.rotate(); err != nil {
+			return
+		}
+		err = db.flush()
+	}
 	return
 }
 
+// Get function returns the message body, the status and the sequence number of the next message to a query.
+// It searches the active memtable, the immutable memtables and the tables.
 func (db *DB) Get(key Key, time ValidTime) (message Message, status Status, nextSequence SequenceNumber, err error) {
-	message, status, nextSequence, err = db.mem.Get(key, time)
-	return
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.get(key, time)
+}
+
+// GetAsOf returns what Get(key, time) would have returned if executed at arrivalTime: it only considers
+// the versions that arrived at or before arrivalTime, and the deletions and amendments that arrived by then
+// (see DeleteAt and AmendAt). The sequence spans of versions dropped by compactions are ignored, since their
+// arrival times are unknown, and so are the deleted versions dropped together with their tombstones.
+func (db *DB) GetAsOf(key Key, time, arrivalTime ValidTime) (message Message, status Status, nextSequence SequenceNumber, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.Quarantined(key) {
+		return Message{}, Status(QUARANTINED), 0, KeyQuarantined{key}
+	}
+	if db.expired(key, time) {
+		return Message{}, Status(EXPIRED), 0, nil
+	}
+	records, err := db.records(key)
+	if err != nil {
+		return Message{}, Status(ERROR), 0, err
+	}
+	visible := records[:0]
+	for _, record := range records {
+		if record, ok := record.asOf(arrivalTime); ok && record.kind != tombstoneKind {
+			visible = append(visible, record)
+		}
+	}
+	var found, next *Message
+	for i := range visible {
+		if visible[i].creationTime > time {
+			next = &visible[i]
+			break
+		}
+		found = &visible[i]
+	}
+	if db.opts.OutOfOrderPolicy == ResortOutOfOrder && found != nil && next != nil && next.sequenceNumber <= found.sequenceNumber {
+		visible = resortVersions(visible)
+		found, next = nil, nil
+		for i := range visible {
+			if visible[i].creationTime > time {
+				next = &visible[i]
+				break
+			}
+			found = &visible[i]
+		}
+	}
+	return resolve(found, next, nil)
+}
+
+// get implements Get for a caller holding mu.
+func (db *DB) get(key Key, time ValidTime) (message Message, status Status, nextSequence SequenceNumber, err error) {
+	if db.expired(key, time) {
+		return Message{}, Status(EXPIRED), 0, nil
+	}
+	found, next, spans, err := db.lookupOrdered(key, time)
+	if err != nil {
+		return Message{}, errorStatus(err), 0, err
+	}
+	return resolve(found, next, spans)
+}
+
+// errorStatus returns the status reported along with a failed lookup.
+func errorStatus(err error) Status {
+	if _, ok := err.(KeyQuarantined); ok {
+		return Status(QUARANTINED)
+	}
+	return Status(ERROR)
+}
+
+// lookupOrdered is like lookup, but skips deleted versions and applies the out-of-order policy: it fails with
+// KeyQuarantined for a quarantined key and re-sorts out-of-order versions under ResortOutOfOrder.
+// It also returns the sequence spans to resolve the version against, which cover the deleted versions.
+func (db *DB) lookupOrdered(key Key, time ValidTime) (message, next *Message, spans seqSpans, err error) {
+	if db.Quarantined(key) {
+		return nil, nil, nil, KeyQuarantined{key}
+	}
+	message, next, err = db.lookup(key, time)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if message.deleted() || next.deleted() {
+		return db.lookupVersions(key, time)
+	}
+	if db.opts.OutOfOrderPolicy != ResortOutOfOrder || message == nil {
+		return message, next, db.spans(key), nil
+	}
+	// re-sort if the version found is out of order with its successor or its predecessor
+	if next != nil && next.sequenceNumber <= message.sequenceNumber {
+		return db.lookupVersions(key, time)
+	}
+	if message.creationTime > 0 {
+		prev, _, err := db.lookup(key, message.creationTime-1)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if prev != nil && prev.sequenceNumber >= message.sequenceNumber {
+			return db.lookupVersions(key, time)
+		}
+	}
+	return message, next, db.spans(key), nil
+}
+
+// lookup merges the versions of key valid at time found in every component, together with their successors.
+// If two components hold the same version, the one from the newer component is returned. The versions returned
+// may be tombstones.
+func (db *DB) lookup(key Key, time ValidTime) (message, next *Message, err error) {
+	for _, c := range db.components() {
+		m, n, err := c.lookup(key, time)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m != nil && (message == nil || compareVersions(*m, *message) > 0) {
+			message = m
+		}
+		if n != nil && (next == nil || compareVersions(*n, *next) < 0) {
+			next = n
+		}
+	}
+	return message, next, nil
+}
+
+// components returns the components of the database, newest first.
+func (db *DB) components() []component {
+	components := make([]component, 0, 1+len(db.imm))
+	components = append(components, db.mem)
+	for i := len(db.imm) - 1; i >= 0; i-- {
+		components = append(components, db.imm[i])
+	}
+	for _, level := range db.levels {
+		for i := len(level) - 1; i >= 0; i-- {
+			components = append(components, level[i])
+		}
+	}
+	return components
+}
+
+func (db *DB) memtableFull() bool {
+	return db.mem.full(db.opts.MemtableSize, db.opts.MemtableTimeSpan)
+}
+
+// rotate archives the active memtable and switches to a new memtable backed by a new write-ahead log.
+func (db *DB) rotate() error {
+	archiveMemtable(db.mem, db.mem.maxTime)
+	db.imm = append(db.imm, db.mem)
+	db.mem = NewMemtable(db.mem.archiveTime)
+	return db.newLog()
+}
+
+func (db *DB) newLog() error {
+	number := db.nextFileNumber
+	db.nextFileNumber++
+	log, err := createWAL(db.fileName(number, "log"))
+	if err != nil {
+		return err
+	}
+	if db.log != nil {
+		if err = db.log.Close(); err != nil {
+			log.Close()
+			return err
+		}
+	}
+	db.log = log
+	db.mem.logNumber = number
+	return nil
+}
+
+// flush writes the immutable memtables to tables in level 0, oldest first, makes their logs obsolete
+// and compacts the levels that became full.
+func (db *DB) flush() error {
+	for len(db.imm) > 0 {
+		mem := db.imm[0]
+		number := db.nextFileNumber
+		db.nextFileNumber++
+		filename := db.fileName(number, "sst")
+		horizon := func(key Key) ValidTime { return db.horizon(key, mem.archiveTime) }
+		if err := minorCompact(mem, filename, db.opts.BlockSize, horizon); err != nil {
+			return err
+		}
+		if err := db.hook("flush: table written"); err != nil {
+			return err
+		}
+		t, err := openTable(filename, number)
+		if err != nil {
+			return err
+		}
+		if len(db.levels) == 0 {
+			db.levels = append(db.levels, nil)
+		}
+		db.levels[0] = append(db.levels[0], t)
+		db.imm = db.imm[1:]
+		db.archiveTime = mem.archiveTime
+		if err = db.writeManifest(); err != nil {
+			return err
+		}
+		if err = db.hook("flush: manifest written"); err != nil {
+			return err
+		}
+		if err = db.removeObsoleteFiles(); err != nil {
+			return err
+		}
+	}
+	return db.maybeCompact()
+}
+
+func (db *DB) hook(point string) error {
+	if db.crashHook == nil {
+		return nil
+	}
+	return db.crashHook(point)
+}
+
+func (db *DB) fileName(number uint64, ext string) string {
+	return filepath.Join(db.path, fmt.Sprintf("%06d.%s", number, ext))
+}
+
+// parseFileName parses a file name produced by fileName.
+func parseFileName(name string) (number uint64, ext string, ok bool) {
+	base, ext, found := strings.Cut(name, ".")
+	if !found {
+		return 0, "", false
+	}
+	number, err := strconv.ParseUint(base, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return number, ext, true
+}
+
+// Stats holds the counters of a database.
+type Stats struct {
+	// Duplicates is the number of writes ignored because they repeat a stored version.
+	Duplicates uint64
+	// Conflicts is the number of writes rejected with ConflictingVersion.
+	Conflicts uint64
+}
+
+// Stats returns the counters of the database.
+func (db *DB) Stats() Stats {
+	return Stats{
+		Duplicates: atomic.LoadUint64(&db.stats.Duplicates),
+		Conflicts:  atomic.LoadUint64(&db.stats.Conflicts),
+	}
 }
 
+// SetSensors passes the sensor properties to the database and to the pool of its queries.
 func (db *DB) SetSensors(sensors map[int][]int) {
+	db.queryMu.Lock()
+	db.queries.SetSensors(sensors)
+	db.queryMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	db.sensors = sensors
 }
@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -14,7 +16,10 @@ func get(db *DB, k uint64, t uint64) {
 }
 
 func TestDB_Get(t *testing.T) {
-	db := NewDB("", 0)
+	db, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	for k := 1; k < 100; k++ {
 		for j := 1; j < 100; j++ {
 			if j%5 == 0 {
@@ -39,7 +44,7 @@ func TestDB_Get(t *testing.T) {
 	get(db, 1, 990)
 	get(db, 1, 1000)
 
-	err := db.Put(Key(100), SequenceNumber(1), ValidTime(10), fmt.Sprintf("value %d", 10))
+	err = db.Put(Key(100), SequenceNumber(1), ValidTime(10), fmt.Sprintf("value %d", 10))
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -47,3 +52,184 @@ func TestDB_Get(t *testing.T) {
 	get(db, 100, 10)
 	get(db, 100, 11)
 }
+
+func TestDB_MinorCompact(t *testing.T) {
+	dir := t.TempDir()
+	memDB, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err := Open(dir, &Options{MemtableSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j < 100; j++ {
+		for k := 1; k < 10; k++ {
+			if j%7 == 0 {
+				continue
+			}
+			for _, db := range []*DB{memDB, diskDB} {
+				if err := db.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if len(diskDB.levels) == 0 {
+		t.Fatal("no table has been flushed")
+	}
+	compareGets(t, memDB, diskDB)
+
+	// the tables and the log survive a restart
+	if err := diskDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err = Open(dir, &Options{MemtableSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	compareGets(t, memDB, diskDB)
+}
+
+func TestDB_ConcurrentPutGet(t *testing.T) {
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 2048, LevelRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+
+	const writers, versions = 4, 150
+	// written[k] is the latest sequence number of key k whose Put has returned
+	var written [writers]uint64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			for j := 1; j <= versions; j++ {
+				if err := diskDB.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+					t.Error(err)
+					return
+				}
+				atomic.StoreUint64(&written[k], uint64(j))
+			}
+		}(w)
+	}
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				k := i % writers
+				seq := atomic.LoadUint64(&written[k])
+				if seq == 0 {
+					continue
+				}
+				// a returned Put is visible to every later Get
+				message, status, _, err := diskDB.Get(Key(k), ValidTime(seq*10))
+				if err != nil || message.SequenceNumber() != SequenceNumber(seq) || (status != OK && status != ODV) {
+					t.Errorf("Get(%d, %d) = %s %s %v, want version %d", k, seq*10, message, status, err, seq)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	for k := 0; k < writers; k++ {
+		message, status, _, err := diskDB.Get(Key(k), ValidTime(versions*10))
+		if err != nil || message.SequenceNumber() != versions || status != ODV {
+			t.Fatalf("Get(%d, %d) = %s %s %v, want version %d ODV", k, versions*10, message, status, err, versions)
+		}
+	}
+	if len(diskDB.levels) == 0 {
+		t.Fatal("no table has been flushed")
+	}
+}
+
+// compareGets checks that two databases give the same answers to Get.
+func compareGets(t *testing.T, want, got *DB) {
+	t.Helper()
+	for k := 0; k < 11; k++ {
+		for time := 0; time < 1010; time += 5 {
+			m1, s1, n1, err1 := want.Get(Key(k), ValidTime(time))
+			m2, s2, n2, err2 := got.Get(Key(k), ValidTime(time))
+			if m1 != m2 || s1 != s2 || n1 != n2 || (err1 == nil) != (err2 == nil) {
+				t.Fatalf("Get(%d, %d) = %s %s %d %v, want %s %s %d %v", k, time, m2, s2, n2, err2, m1, s1, n1, err1)
+			}
+		}
+	}
+}
+
+func TestDB_GetAsOf(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 512, LevelRuns: 2}
+	diskDB, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// version j of key k arrives at 10*j+5, except for every fifth version, which arrives 100 later
+	for j := 1; j < 100; j++ {
+		for k := 1; k < 4; k++ {
+			arrival := ValidTime(j*10 + 5)
+			if j%5 == 0 {
+				arrival += 100
+			}
+			if err := diskDB.PutAt(Key(k), SequenceNumber(j), ValidTime(j*10), arrival, fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check := func() {
+		t.Helper()
+		for _, c := range []struct {
+			time, arrival ValidTime
+			seq           SequenceNumber
+			status        Status
+			next          SequenceNumber
+		}{
+			{100, 0, 0, NOTFOUND, 0},
+			{100, 10, 0, NOTFOUND, 0},
+			{100, 15, 1, ODV, 0},
+			{100, 104, 9, ODV, 0},
+			{100, 204, 9, HOLE, 11},
+			{100, 205, 10, OK, 11},
+			{55, 80, 4, HOLE, 6},
+			{55, 155, 5, OK, 6},
+			{100, OpenEnd, 10, OK, 11},
+		} {
+			message, status, next, err := diskDB.GetAsOf(Key(2), c.time, c.arrival)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.SequenceNumber() != c.seq || status != c.status || next != c.next {
+				t.Fatalf("GetAsOf(2, %d, %d) = %s %s %d, want version %d %s %d", c.time, c.arrival, message, status, next, c.seq, c.status, c.next)
+			}
+			if c.seq != 0 && message.ArrivalTime() > c.arrival {
+				t.Fatalf("GetAsOf(2, %d, %d) returned a version arrived at %d", c.time, c.arrival, message.ArrivalTime())
+			}
+		}
+	}
+	check()
+
+	// arrival times survive flushes, compactions and restarts
+	if err := diskDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	check()
+}
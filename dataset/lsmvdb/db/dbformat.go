@@ -1,9 +1,12 @@
 package db
 
 import (
+	"container/heap"
 	"fmt"
 	"gonum.org/v1/gonum/stat/distuv"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,16 +22,40 @@ const (
 	HOLE            = 2
 	NOTFOUND        = 3
 	ERROR           = 4
+	// QUARANTINED marks a key quarantined by the QuarantineOutOfOrder policy.
+	QUARANTINED = 5
+	// EXPIRED marks a read below the retention horizon of a key, where versions may have been dropped.
+	EXPIRED = 6
 )
 
 const (
 	NotCompleted  Reason = 0
-	nonODV               = 1
-	Timeout              = 2
-	MaybeCorrect         = 3
-	KeyNotInQuery        = 4
+	NonODV        Reason = 1 // every key is OK
+	Timeout       Reason = 2 // the deadline passed
+	MaybeCorrect  Reason = 3 // the probability of temporal correctness reached the correctness threshold
+	KeyNotInQuery Reason = 4
+	Quarantined   Reason = 5
 )
 
+func (r Reason) String() string {
+	switch r {
+	case NotCompleted:
+		return "NotCompleted"
+	case NonODV:
+		return "NonODV"
+	case Timeout:
+		return "Timeout"
+	case MaybeCorrect:
+		return "MaybeCorrect"
+	case KeyNotInQuery:
+		return "KeyNotInQuery"
+	case Quarantined:
+		return "Quarantined"
+	default:
+		return "Unknown"
+	}
+}
+
 func (s Status) String() string {
 	switch s {
 	case OK:
@@ -41,6 +68,10 @@ func (s Status) String() string {
 		return "NOTFOUND"
 	case ERROR:
 		return "ERROR"
+	case QUARANTINED:
+		return "QUARANTINED"
+	case EXPIRED:
+		return "EXPIRED"
 	default:
 		return "UNKNOWN"
 	}
@@ -48,16 +79,46 @@ func (s Status) String() string {
 
 // Message defines the message body stored in the memtable.
 // it consists of the start valid time, the sequence number and the message value.
+// The value is stored encoded, together with its type (see Value).
+// arrivalTime records when the message reached the database. A message with arrival time 0 is visible
+// to every query as of an arrival time.
+// A tombstone or an amendment records when the correction reached the database in correctionTime, and keeps
+// the records it replaced in superseded, so that GetAsOf can read the version as it was before (see appendRecord).
 type Message struct {
 	creationTime   ValidTime
 	sequenceNumber SequenceNumber
 	value          string
+	valueType      ValueType
+	arrivalTime    ValidTime
+	kind           messageKind
+	correctionTime ValidTime
+	superseded     string
 }
 
+// messageKind distinguishes the records stored for a version. Tombstones and amendments carry the creation time and
+// the sequence number of the version they apply to, so that they shadow it like a newer copy of the version would.
+type messageKind uint8
+
+const (
+	valueKind     messageKind = iota // a version as written by Put
+	tombstoneKind                    // the version has been retracted by Delete
+	amendmentKind                    // the value of the version has been corrected by Amend
+)
+
 func NewMessage(creationTime ValidTime, sequenceNumber SequenceNumber, value string) *Message {
 	return &Message{creationTime: creationTime, sequenceNumber: sequenceNumber, value: value}
 }
 
+// NewMessageAt is like NewMessage, but records the arrival time of the message.
+func NewMessageAt(creationTime ValidTime, sequenceNumber SequenceNumber, arrivalTime ValidTime, value string) *Message {
+	return &Message{creationTime: creationTime, sequenceNumber: sequenceNumber, value: value, arrivalTime: arrivalTime}
+}
+
+// NewValueMessage is like NewMessageAt, but carries a typed value.
+func NewValueMessage(creationTime ValidTime, sequenceNumber SequenceNumber, arrivalTime ValidTime, value Value) *Message {
+	return &Message{creationTime: creationTime, sequenceNumber: sequenceNumber, value: value.data, valueType: value.typ, arrivalTime: arrivalTime}
+}
+
 func (m Message) CreationTime() ValidTime {
 	return m.creationTime
 }
@@ -66,16 +127,27 @@ func (m Message) SequenceNumber() SequenceNumber {
 	return m.sequenceNumber
 }
 
+// Value returns the encoded value of the message, which is the value itself for a string.
 func (m Message) Value() string {
 	return m.value
 }
 
-func (m Message) ExtractKey() float64 {
-	return float64(m.creationTime)
+// Payload returns the typed value of the message.
+func (m Message) Payload() Value {
+	return Value{typ: m.valueType, data: m.value}
+}
+
+func (m Message) ArrivalTime() ValidTime {
+	return m.arrivalTime
+}
+
+// Amended reports whether the value of the version has been corrected by Amend.
+func (m Message) Amended() bool {
+	return m.kind == amendmentKind
 }
 
 func (m Message) String() string {
-	return fmt.Sprintf("[vt = %09d, seq = %09d, value = %s]", m.creationTime, m.sequenceNumber, m.value)
+	return fmt.Sprintf("[vt = %09d, seq = %09d, value = %s]", m.creationTime, m.sequenceNumber, m.Payload())
 }
 
 /*
@@ -89,6 +161,8 @@ type Result struct {
 	probTemporalCorrect float64
 }
 
+// ResultWithInterval holds a version together with its validity interval [start, end).
+// The interval ends at the creation time of the succeeding version, or at OpenEnd if there is none.
 type ResultWithInterval struct {
 	message *Message
 	start   ValidTime
@@ -96,17 +170,38 @@ type ResultWithInterval struct {
 }
 
 type Query struct {
+	id                  uint64 // identifies the query in its pool
 	arrivalTime         ValidTime
 	requestTime         ValidTime
-	incomplete          int     // number of uncompleted keys
-	probTemporalCorrect float64 // the probability that the query is temporally correct
+	incomplete          int       // number of uncompleted keys
+	deadline            ValidTime // the time the query waits for its final results after its arrival
+	ck                  float64   // the correctness threshold at which the query completes
+	probTemporalCorrect float64   // the probability that the query is temporally correct
 	currentResults      map[Key]*Result
-	pool                *QueryPool // the pool that the query belongs to
+	pool                *QueryPool    // the pool that the query belongs to
+	done                chan struct{} // closed when the query completes
+	index               int           // the index of the query in the expiry heap of its pool while it is pending
+
+	// mu guards the fields below and the statuses of the results, which the accessors read while a write
+	// drives the pool
+	mu          sync.Mutex
+	subscribers []func(query *Query, final bool)
+	finished    bool
+	retracted   bool      // whether a version in the results has been deleted or amended since
+	reason      Reason    // the reason of the completion
+	completedAt ValidTime // the clock at the completion
 }
 
 type QueryPool struct {
-	size int
-	pool map[Key]map[ValidTime]*Query
+	size   int
+	pool   map[Key]map[uint64]*Query // the pending queries of each key by id
+	policy OutOfOrderPolicy          // how to handle a message whose sequence number is out of order
+	// completed holds the completed queries until they are forgotten, so that Retract can flag them,
+	// if retain is set
+	completed map[Key]map[uint64]*Query
+	retain    bool
+	// expiry orders the pending queries by their deadline, so that Advance finds the expired ones
+	expiry queryHeap
 
 	// helper field for experiments
 	sensors         map[int][]int // stores the sensor properties
@@ -118,6 +213,18 @@ func (r *Result) Message() *Message {
 	return r.message
 }
 
+func (r *Result) Status() Status {
+	return r.status
+}
+
+func (r *Result) NextSequence() SequenceNumber {
+	return r.nextSequence
+}
+
+func (r *Result) ProbTemporalCorrect() float64 {
+	return r.probTemporalCorrect
+}
+
 func (r *ResultWithInterval) Message() *Message {
 	return r.message
 }
@@ -130,7 +237,10 @@ func (r *ResultWithInterval) End() ValidTime {
 	return r.end
 }
 
+// OverlappingInterval returns the intersection [lo, hi) of the validity intervals of results.
+// ok reports whether the intersection is not empty.
 func OverlappingInterval(results map[Key]ResultWithInterval) (lo, hi ValidTime, ok bool) {
+	hi = OpenEnd
 	for _, result := range results {
 		if result.Start() > lo {
 			lo = result.Start()
@@ -139,11 +249,111 @@ func OverlappingInterval(results map[Key]ResultWithInterval) (lo, hi ValidTime,
 			hi = result.End()
 		}
 	}
-	return lo, hi, lo <= hi
+	return lo, hi, lo < hi
+}
+
+// lastQueryID is the id of the latest query created by NewQuery.
+var lastQueryID uint64
+
+// Deadline returns the time the query waits for its final results after its arrival.
+func (q *Query) Deadline() ValidTime {
+	return q.deadline
+}
+
+// Correctness returns the correctness threshold of the query.
+func (q *Query) Correctness() float64 {
+	return q.ck
+}
+
+// expiresAt returns the time the deadline of the query passes, or OpenEnd if it never does.
+func (q *Query) expiresAt() ValidTime {
+	if q.deadline > OpenEnd-q.arrivalTime {
+		return OpenEnd
+	}
+	return q.arrivalTime + q.deadline
+}
+
+// expired reports whether the deadline of the query has passed at clock.
+// The deadline may be OpenEnd, so the sum must not overflow.
+func (q *Query) expired(clock ValidTime) bool {
+	return clock > q.arrivalTime && clock-q.arrivalTime > q.deadline
+}
+
+// NewQuery creates a query arriving at arrivalTime for the versions valid at requestTime. Once in a pool, the query
+// waits for its final results until deadline after its arrival, unless its probability of temporal correctness
+// reaches the correctness threshold ck first. A deadline of OpenEnd never passes.
+func NewQuery(arrivalTime, requestTime ValidTime, incomplete int, deadline ValidTime, ck float64) *Query {
+	return &Query{
+		id:             atomic.AddUint64(&lastQueryID, 1),
+		arrivalTime:    arrivalTime,
+		requestTime:    requestTime,
+		incomplete:     incomplete,
+		deadline:       deadline,
+		ck:             ck,
+		currentResults: make(map[Key]*Result),
+		done:           make(chan struct{}),
+	}
 }
 
-func NewQuery(arrivalTime, requestTime ValidTime, incomplete int) *Query {
-	return &Query{arrivalTime: arrivalTime, requestTime: requestTime, incomplete: incomplete, currentResults: make(map[Key]*Result)}
+// Subscribe registers fn to be called with the query after every update of its results, and once more with final
+// set when the query completes. If the query has already completed, fn is called at once.
+//
+// fn runs on the goroutine updating the pool, e.g., the write driving the pool of a DB, while the pool is held:
+// it must not block or call into the pool, and should hand the results off, e.g., to a channel with room for them.
+func (q *Query) Subscribe(fn func(query *Query, final bool)) {
+	q.mu.Lock()
+	if q.finished {
+		q.mu.Unlock()
+		fn(q, true)
+		return
+	}
+	q.subscribers = append(q.subscribers, fn)
+	q.mu.Unlock()
+}
+
+// notify calls the subscribers of the query.
+func (q *Query) notify(final bool) {
+	q.mu.Lock()
+	subscribers := q.subscribers
+	q.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(q, final)
+	}
+}
+
+// Reason returns the reason the query completed for, or NotCompleted while it is pending.
+func (q *Query) Reason() Reason {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.reason
+}
+
+// CompletedAt returns the clock at which the query completed.
+func (q *Query) CompletedAt() ValidTime {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.completedAt
+}
+
+// Statuses returns the status of the result of every key of the query.
+func (q *Query) Statuses() map[Key]Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	statuses := make(map[Key]Status, len(q.currentResults))
+	for key, result := range q.currentResults {
+		statuses[key] = result.status
+	}
+	return statuses
+}
+
+// complete marks the query completed at clock for reason and notifies its subscribers.
+func (q *Query) complete(clock ValidTime, reason Reason) {
+	q.mu.Lock()
+	q.reason, q.completedAt = reason, clock
+	q.finished = true
+	close(q.done)
+	q.mu.Unlock()
+	q.notify(true)
 }
 
 func (q *Query) SetPool(pool *QueryPool) {
@@ -154,10 +364,23 @@ func (q *Query) ArrivalTime() ValidTime {
 	return q.arrivalTime
 }
 
+// Done returns a channel that is closed when the query completes, i.e., when its results are final, or no longer
+// refined because its deadline has passed.
+func (q *Query) Done() <-chan struct{} {
+	return q.done
+}
+
 func (q *Query) Result(key Key) *Result {
 	return q.currentResults[key]
 }
 
+// Retracted reports whether a version the query returned has been deleted or amended after the query completed.
+func (q *Query) Retracted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.retracted
+}
+
 func (q *Query) CompleteOneKey() {
 	q.incomplete--
 }
@@ -167,6 +390,8 @@ func (q *Query) AllKeysOK() bool {
 }
 
 func (q *Query) NewResult(key Key, message *Message, status Status, nextSequence SequenceNumber, prob float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	q.currentResults[key] = &Result{message: message, status: status, nextSequence: nextSequence, probTemporalCorrect: prob}
 	q.updateProbTemporalCorrect()
 }
@@ -179,26 +404,32 @@ func (q *Query) updateProbTemporalCorrect() {
 	q.probTemporalCorrect = prob
 }
 
-func (q *Query) MaybeCorrect(ck float64) bool {
-	return q.probTemporalCorrect >= ck
+// MaybeCorrect reports whether the probability of temporal correctness of the query reached its correctness threshold.
+func (q *Query) MaybeCorrect() bool {
+	return q.probTemporalCorrect >= q.ck
 }
 
-func (q *Query) Update(clock ValidTime, key Key, newMessage *Message, deadline ValidTime, ck float64) (completed, updated bool, finalReason Reason) {
+func (q *Query) Update(clock ValidTime, key Key, newMessage *Message) (completed, updated bool, finalReason Reason) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	// check if the key is in the query
 	currentResult, exist := q.currentResults[key]
 	if exist != true {
 		return false, false, KeyNotInQuery
 	}
 	// check if the query expires
-	if clock > q.arrivalTime+deadline {
+	if q.expired(clock) {
 		return true, false, Timeout
 	}
 
 	// update the individual key requested in query
 	keyCompleted, keyUpdated, reason := q.updateKey(key, currentResult, newMessage)
+	if reason == Quarantined {
+		return true, keyUpdated, Quarantined
+	}
 	if keyUpdated {
 		q.updateProbTemporalCorrect()
-		if q.probTemporalCorrect >= ck {
+		if q.MaybeCorrect() {
 			return true, keyUpdated, MaybeCorrect
 		}
 	}
@@ -218,6 +449,10 @@ func (q *Query) Update(clock ValidTime, key Key, newMessage *Message, deadline V
 //   - completed: whether the key is non ODV
 //   - updated: whether the current result message is updated
 //   - reason: the reason for the completion of the query on the data stream
+//
+// A new message whose sequence number contradicts its creation time is handled by the out-of-order policy of the pool.
+// RejectOutOfOrder ignores it. So does ResortOutOfOrder, because re-sorting moves the message before the current one.
+// QuarantineOutOfOrder marks the key QUARANTINED and returns the reason Quarantined.
 func (q *Query) updateKey(key Key, currentResult *Result, newMessage *Message) (completed, updated bool, reason Reason) {
 	currentMessage := currentResult.message
 	if newMessage.CreationTime() > q.requestTime {
@@ -233,7 +468,7 @@ func (q *Query) updateKey(key Key, currentResult *Result, newMessage *Message) (
 			// new message is the immediate successor of the current message, so we can confirm that the current message is not an ODV
 			currentResult.nextSequence = newMessage.SequenceNumber()
 			currentResult.status = OK
-			return true, false, nonODV
+			return true, false, NonODV
 		} else if newMessage.SequenceNumber() > currentMessage.SequenceNumber()+1 {
 			if currentResult.status == ODV {
 				currentResult.nextSequence = newMessage.SequenceNumber()
@@ -245,20 +480,22 @@ func (q *Query) updateKey(key Key, currentResult *Result, newMessage *Message) (
 			currentResult.status = HOLE
 			return false, false, NotCompleted
 		} else {
-			// TODO: handle out of order sequence number
-			panic(SequenceOutOfOrder{curr: newMessage.SequenceNumber(), next: currentMessage.SequenceNumber()})
+			// the new message is created after the current one, but precedes it in sequence
+			return q.outOfOrder(currentResult)
 		}
 	} else {
 		// newMessage.CreationTime() <= q.requestTime means the new message MAY be covered by the requested time range of the query
+		if currentResult.status == HOLE && newMessage.SequenceNumber() >= currentResult.nextSequence {
+			// the new message is created before the request time, but does not precede the known successor
+			return q.outOfOrder(currentResult)
+		}
 		if newMessage.SequenceNumber() < currentMessage.SequenceNumber() {
 			return false, false, NotCompleted
 		} else {
 			// update entry because the new message is newer, and was valid at the requested time
 			currentResult.message = newMessage
 			// re-calculates the probability of temporal correctness
-			mean := q.pool.sensors[int(key)][0]
-			std := q.pool.sensors[int(key)][1]
-			currentResult.probTemporalCorrect = ProbTemporalCorrect(mean, std, currentMessage.CreationTime(), q.requestTime)
+			currentResult.probTemporalCorrect = probTemporalCorrect(q.pool.sensors, key, currentMessage.CreationTime(), q.requestTime)
 			if currentResult.status == ODV {
 				// new message is still ODV
 				return false, true, NotCompleted
@@ -266,7 +503,7 @@ func (q *Query) updateKey(key Key, currentResult *Result, newMessage *Message) (
 				// current message is followed by a hole
 				if newMessage.SequenceNumber() == currentResult.nextSequence-1 {
 					currentResult.status = OK
-					return true, true, nonODV
+					return true, true, NonODV
 				} else {
 					currentResult.status = HOLE
 					return false, true, NotCompleted
@@ -276,8 +513,17 @@ func (q *Query) updateKey(key Key, currentResult *Result, newMessage *Message) (
 	}
 }
 
+// outOfOrder applies the out-of-order policy of the pool to the result of a key receiving an out-of-order message.
+func (q *Query) outOfOrder(currentResult *Result) (completed, updated bool, reason Reason) {
+	if q.pool == nil || q.pool.policy != QuarantineOutOfOrder {
+		return false, false, NotCompleted
+	}
+	currentResult.status = QUARANTINED
+	return false, false, Quarantined
+}
+
 func NewQueryPool() *QueryPool {
-	return &QueryPool{pool: make(map[Key]map[ValidTime]*Query)}
+	return &QueryPool{pool: make(map[Key]map[uint64]*Query), completed: make(map[Key]map[uint64]*Query)}
 }
 
 func (qp *QueryPool) UpdateCount() int {
@@ -295,24 +541,33 @@ func (qp *QueryPool) UpdateAverageTime() float64 {
 func (qp *QueryPool) Add(query *Query) {
 	for key, _ := range query.currentResults {
 		if _, exist := qp.pool[key]; exist != true {
-			qp.pool[key] = make(map[ValidTime]*Query)
+			qp.pool[key] = make(map[uint64]*Query)
 		}
-		qp.pool[key][query.arrivalTime] = query
+		qp.pool[key][query.id] = query
 	}
 	qp.size++
+	heap.Push(&qp.expiry, query)
 }
 
 func (qp *QueryPool) SetSensors(sensors map[int][]int) {
 	qp.sensors = sensors
 }
 
+// SetOutOfOrderPolicy sets how the queries of the pool handle a message whose sequence number is out of order.
+func (qp *QueryPool) SetOutOfOrderPolicy(policy OutOfOrderPolicy) {
+	qp.policy = policy
+}
+
 // Update scans the query pool upon the arrival of a new message newMessage to update corresponding queries.
 // It returns a list of completed queries and a list of updated queries.
-//   - completedQueries: a slice of completed queries which are removed from the query pool
+//   - completedQueries: a slice of completed queries which are removed from the query pool. Each records the reason
+//     of its completion and the clock.
 //   - updatedQueries: a slice of queries. In such a query, at least one of its current data stream is updated with newMessage.
 //
 // Note that if a query is completed, it will NOT appear in the updatedQueries list.
-func (qp *QueryPool) Update(clock ValidTime, key Key, newMessage *Message, deadline ValidTime, ck float64) (completedQueries, updatedQueries []*Query) {
+// The subscribers of the updated and completed queries are notified as well (see Query.Subscribe).
+// Each query is held to its own deadline and correctness threshold (see NewQuery).
+func (qp *QueryPool) Update(clock ValidTime, key Key, newMessage *Message) (completedQueries, updatedQueries []*Query) {
 	// update the query pool
 	queries, exist := qp.pool[key]
 	if exist != true {
@@ -321,26 +576,118 @@ func (qp *QueryPool) Update(clock ValidTime, key Key, newMessage *Message, deadl
 	// update each query
 	completedQueries = make([]*Query, 0)
 	updatedQueries = make([]*Query, 0)
-	for arrivalTime, query := range queries {
-		// TODO: handle query completion reasons
+	for _, query := range queries {
 		startTime := time.Now()
-		completed, updated, _ := query.Update(clock, key, newMessage, deadline, ck)
+		completed, updated, reason := query.Update(clock, key, newMessage)
 		qp.updateTotalTime += time.Since(startTime).Microseconds()
 		qp.updateCount++
 		if completed {
 			completedQueries = append(completedQueries, query)
-			// remove the reference to the query from each key
-			for key, _ := range query.currentResults {
-				delete(qp.pool[key], arrivalTime)
+			completedAt := clock
+			if reason == Timeout {
+				// the query expired at its deadline, before the message arrived
+				completedAt = query.expiresAt()
 			}
-			qp.size--
+			qp.remove(query)
+			qp.retire(query, completedAt, reason)
 		} else if updated {
 			updatedQueries = append(updatedQueries, query)
+			query.notify(false)
 		}
 	}
 	return // completedQueries
 }
 
+// remove removes a pending query from the pool.
+func (qp *QueryPool) remove(query *Query) {
+	// remove the reference to the query from each key
+	for key, _ := range query.currentResults {
+		delete(qp.pool[key], query.id)
+	}
+	qp.size--
+	heap.Remove(&qp.expiry, query.index)
+}
+
+// Execute runs the first execution of query: it reads the versions of keys valid at the request time of the query
+// with get, which is the Get of the engine holding them. A query whose keys are all OK, or whose probability of
+// temporal correctness reaches its correctness threshold, completes at once at its arrival time, and Retract flags
+// it like the queries completed by Update. Otherwise, it is added to the pool, and the versions passed to Update
+// refine it. Execute reports whether the query was added, and fails with the error of get.
+// keys must not repeat a key.
+func (qp *QueryPool) Execute(query *Query, keys []Key, get func(Key, ValidTime) (Message, Status, SequenceNumber, error)) (pending bool, err error) {
+	for _, key := range keys {
+		message, status, nextSequence, err := get(key, query.requestTime)
+		if err != nil {
+			return false, err
+		}
+		prob := probTemporalCorrect(qp.sensors, key, message.CreationTime(), query.requestTime)
+		query.NewResult(key, &message, status, nextSequence, prob)
+		if status == OK {
+			query.CompleteOneKey()
+		}
+	}
+	if query.AllKeysOK() {
+		qp.retire(query, query.arrivalTime, NonODV)
+		return false, nil
+	}
+	if query.MaybeCorrect() {
+		qp.retire(query, query.arrivalTime, MaybeCorrect)
+		return false, nil
+	}
+	qp.Add(query)
+	query.SetPool(qp)
+	return true, nil
+}
+
+// retire completes query at clock for reason and adds it to the completed queries if the pool retains them,
+// so that Retract flags it.
+func (qp *QueryPool) retire(query *Query, clock ValidTime, reason Reason) {
+	if qp.retain {
+		for key, _ := range query.currentResults {
+			if _, exist := qp.completed[key]; exist != true {
+				qp.completed[key] = make(map[uint64]*Query)
+			}
+			qp.completed[key][query.id] = query
+		}
+	}
+	query.complete(clock, reason)
+}
+
+// SetRetain sets whether the pool keeps the completed queries until Forget drops them, so that Retract flags them.
+// By default, Retract flags no query.
+func (qp *QueryPool) SetRetain(retain bool) {
+	qp.retain = retain
+}
+
+// Retract flags the completed queries whose result for key holds the version with the given sequence number,
+// after the version has been deleted or amended in the database. It returns the flagged queries.
+func (qp *QueryPool) Retract(key Key, sequenceNumber SequenceNumber) (retractedQueries []*Query) {
+	for _, query := range qp.completed[key] {
+		message := query.currentResults[key].message
+		if message != nil && message.SequenceNumber() == sequenceNumber {
+			query.mu.Lock()
+			query.retracted = true
+			query.mu.Unlock()
+			retractedQueries = append(retractedQueries, query)
+		}
+	}
+	return
+}
+
+// Forget drops the completed queries that arrived before arrivalTime. Retract no longer flags them.
+func (qp *QueryPool) Forget(arrivalTime ValidTime) {
+	for key, queries := range qp.completed {
+		for id, query := range queries {
+			if query.arrivalTime < arrivalTime {
+				delete(queries, id)
+			}
+		}
+		if len(queries) == 0 {
+			delete(qp.completed, key)
+		}
+	}
+}
+
 /*
  * The probability of a message to be temporal correct.
  */
@@ -353,6 +700,15 @@ func ProbTemporalCorrect(mean, stddev int, creationTime, requestTime ValidTime)
 	return 1.0 - dist.CDF(float64(requestTime-creationTime))
 }
 
+// probTemporalCorrect returns ProbTemporalCorrect for the sensor of key, or 0 if its properties are unknown.
+func probTemporalCorrect(sensors map[int][]int, key Key, creationTime, requestTime ValidTime) float64 {
+	properties, exist := sensors[int(key)]
+	if !exist {
+		return 0
+	}
+	return ProbTemporalCorrect(properties[0], properties[1], creationTime, requestTime)
+}
+
 /*
  * Error definitions
  */
@@ -366,3 +722,100 @@ type SequenceOutOfOrder struct {
 func (o SequenceOutOfOrder) Error() string {
 	return fmt.Sprintf("Error: Sequences are out of order. curr = %d, next = %d", o.curr, o.next)
 }
+
+// CorruptedFile defines an error where a database file fails validation.
+type CorruptedFile struct {
+	filename string
+	reason   string
+}
+
+func (c CorruptedFile) Error() string {
+	return fmt.Sprintf("Error: file %s is corrupted: %s", c.filename, c.reason)
+}
+
+// UnsupportedFormatVersion defines an error where a table file or a write-ahead log is written in an unknown format version
+type UnsupportedFormatVersion struct {
+	filename string
+	version  uint32
+}
+
+func (u UnsupportedFormatVersion) Error() string {
+	return fmt.Sprintf("Error: file %s has unsupported format version %d", u.filename, u.version)
+}
+
+// InconsistentResults defines an error where the version of a key is not valid during a common interval
+// with the versions of the other keys of a query
+type InconsistentResults struct {
+	key  Key
+	time ValidTime
+}
+
+func (i InconsistentResults) Error() string {
+	return fmt.Sprintf("Error: key %d has no version valid at %d during a common interval with the other keys", i.key, i.time)
+}
+
+// Key returns the key breaking the consistency of the query.
+func (i InconsistentResults) Key() Key {
+	return i.key
+}
+
+// CreationTimeNotIncreasing defines an error where a version with an assigned sequence number is not created
+// after the latest version of its key
+type CreationTimeNotIncreasing struct {
+	key          Key
+	latest       ValidTime
+	creationTime ValidTime
+}
+
+func (c CreationTimeNotIncreasing) Error() string {
+	return fmt.Sprintf("Error: key %d: creation time %d is not after the latest version created at %d", c.key, c.creationTime, c.latest)
+}
+
+// KeyQuarantined defines an error where a key has been quarantined because of an out-of-order sequence number
+type KeyQuarantined struct {
+	key Key
+}
+
+func (k KeyQuarantined) Error() string {
+	return fmt.Sprintf("Error: key %d is quarantined because of out-of-order sequence numbers", k.key)
+}
+
+// ConflictingVersion defines an error where a key already holds a different version with the same sequence number
+type ConflictingVersion struct {
+	key            Key
+	sequenceNumber SequenceNumber
+}
+
+func (c ConflictingVersion) Error() string {
+	return fmt.Sprintf("Error: key %d already holds a different version with sequence number %d", c.key, c.sequenceNumber)
+}
+
+// VersionNotFound defines an error where a key holds no version with the sequence number to delete or amend
+type VersionNotFound struct {
+	key            Key
+	sequenceNumber SequenceNumber
+}
+
+func (v VersionNotFound) Error() string {
+	return fmt.Sprintf("Error: key %d holds no version with sequence number %d", v.key, v.sequenceNumber)
+}
+
+// VersionExpired defines an error where the version to delete is valid at or below the retention horizon of its key
+type VersionExpired struct {
+	key            Key
+	sequenceNumber SequenceNumber
+}
+
+func (v VersionExpired) Error() string {
+	return fmt.Sprintf("Error: key %d: version with sequence number %d is below the retention horizon", v.key, v.sequenceNumber)
+}
+
+// ValueTypeMismatch defines an error where a value is decoded as a type it does not hold
+type ValueTypeMismatch struct {
+	want ValueType
+	got  ValueType
+}
+
+func (v ValueTypeMismatch) Error() string {
+	return fmt.Sprintf("Error: value of type %s decoded as %s", v.got, v.want)
+}
@@ -0,0 +1,46 @@
+package db
+
+// queryHeap is a min-heap of the pending queries ordered by the time their deadline passes, so that the query
+// expiring first is at the top. Each query holds its index in the heap, so that the pool removes it as soon as
+// it completes.
+type queryHeap []*Query
+
+func (h queryHeap) Len() int           { return len(h) }
+func (h queryHeap) Less(i, j int) bool { return h[i].expiresAt() < h[j].expiresAt() }
+
+func (h queryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *queryHeap) Push(x any) {
+	query := x.(*Query)
+	query.index = len(*h)
+	*h = append(*h, query)
+}
+
+func (h *queryHeap) Pop() any {
+	old := *h
+	query := old[len(old)-1]
+	old[len(old)-1] = nil
+	query.index = -1
+	*h = old[:len(old)-1]
+	return query
+}
+
+// Advance moves the clock of the pool to clock and completes the pending queries whose own deadline has passed,
+// i.e., those that arrived more than their deadline before clock, regardless of whether messages arrived for their
+// keys. Each expired query completes with the reason Timeout at its exact deadline. Advance returns the expired
+// queries, which are removed from the pool.
+func (qp *QueryPool) Advance(clock ValidTime) (expiredQueries []*Query) {
+	for qp.expiry.Len() > 0 {
+		query := qp.expiry[0]
+		if !query.expired(clock) {
+			break
+		}
+		qp.remove(query)
+		qp.retire(query, query.expiresAt(), Timeout)
+		expiredQueries = append(expiredQueries, query)
+	}
+	return expiredQueries
+}
@@ -0,0 +1,48 @@
+package db
+
+import "testing"
+
+func TestQueryPool_Advance(t *testing.T) {
+	pool := NewQueryPool()
+	queries := make([]*Query, 5)
+	// query 2 has a tighter deadline than the queries before it, and query 4 has none
+	deadlines := []ValidTime{50, 50, 20, 50, OpenEnd}
+	for i := range queries {
+		// keys 1 and 2 alternate, and the first query asks for both
+		queries[i] = NewQuery(ValidTime(100+10*i), 25, 1, deadlines[i], 1.0)
+		queries[i].NewResult(Key(1+i%2), NewMessage(20, 2, "value 2"), ODV, 0, 0.5)
+		if i == 0 {
+			queries[i].incomplete = 2
+			queries[i].NewResult(2, NewMessage(20, 2, "value 2"), ODV, 0, 0.5)
+		}
+		pool.Add(queries[i])
+		queries[i].SetPool(pool)
+	}
+	// the successor of key 2 completes queries 0, 1 and 3 before their deadline
+	pool.Update(112, 2, NewMessage(30, 3, "value 3"))
+	if !isDone(queries[1]) || !isDone(queries[3]) || isDone(queries[0]) {
+		t.Fatal("wrong queries completed by the update of key 2")
+	}
+
+	if expired := pool.Advance(140); len(expired) != 0 {
+		t.Fatalf("Advance(140) expired %d queries, want 0", len(expired))
+	}
+	// query 2 arrived after query 0, but expires first
+	expired := pool.Advance(160)
+	if len(expired) != 2 || expired[0] != queries[2] || expired[1] != queries[0] {
+		t.Fatalf("Advance(160) expired %v, want queries 2 and 0", expired)
+	}
+	for _, query := range expired {
+		if !isDone(query) || query.Reason() != Timeout || query.CompletedAt() != query.ArrivalTime()+query.Deadline() {
+			t.Fatalf("expired query completed at %d with %s", query.CompletedAt(), query.Reason())
+		}
+	}
+	// the completed queries leave the heap at once
+	if pool.size != 1 || pool.expiry.Len() != 1 || pool.expiry[0] != queries[4] || queries[4].index != 0 {
+		t.Fatalf("%d pending queries and %d in the heap, want query 4 only", pool.size, pool.expiry.Len())
+	}
+	// a query without a deadline never expires
+	if expired := pool.Advance(OpenEnd); len(expired) != 0 {
+		t.Fatal("Advance expired a query without a deadline")
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+// GetInterval is like Get, but returns the version together with its validity interval
+// [creationTime, successor.creationTime). The interval of an ODV version is open and ends at OpenEnd.
+// If no version is valid at time, the result holds no message and the interval [0, successor.creationTime)
+// in which the key has no version.
+func (db *DB) GetInterval(key Key, time ValidTime) (result ResultWithInterval, status Status, nextSequence SequenceNumber, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getInterval(key, time)
+}
+
+// getInterval implements GetInterval for a caller holding mu.
+func (db *DB) getInterval(key Key, time ValidTime) (result ResultWithInterval, status Status, nextSequence SequenceNumber, err error) {
+	if db.expired(key, time) {
+		return ResultWithInterval{}, Status(EXPIRED), 0, nil
+	}
+	found, next, spans, err := db.lookupOrdered(key, time)
+	if err != nil {
+		return ResultWithInterval{}, errorStatus(err), 0, err
+	}
+	message, status, nextSequence, err := resolve(found, next, spans)
+	if err != nil {
+		return ResultWithInterval{}, status, nextSequence, err
+	}
+
+	result.end = OpenEnd
+	if next != nil {
+		result.end = next.creationTime
+	}
+	if found != nil {
+		result.message = &message
+		result.start = message.creationTime
+	}
+	return result, status, nextSequence, nil
+}
+
+// GetConsistent returns the versions of keys valid at time, provided that they are all valid during a common
+// interval containing time. It returns the results and the common interval [lo, hi).
+//
+// The interval of a HOLE version is narrowed to [creationTime, creationTime+1): a version that has not arrived
+// yet may start right after it, so the version is only known to be valid at its creation time.
+// If a key has no version valid at time, or its interval does not contain time, GetConsistent returns the results
+// gathered so far and an InconsistentResults error naming that key.
+func (db *DB) GetConsistent(keys []Key, time ValidTime) (results map[Key]ResultWithInterval, lo, hi ValidTime, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	results = make(map[Key]ResultWithInterval, len(keys))
+	for _, key := range keys {
+		result, status, _, err := db.getInterval(key, time)
+		if err != nil {
+			return results, 0, 0, err
+		}
+		if status == HOLE {
+			result.end = result.start + 1
+		}
+		results[key] = result
+		if status == NOTFOUND || time >= result.end {
+			return results, 0, 0, InconsistentResults{key, time}
+		}
+	}
+	lo, hi, _ = OverlappingInterval(results)
+	return results, lo, hi, nil
+}
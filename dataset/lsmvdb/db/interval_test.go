@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_GetInterval(t *testing.T) {
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 512, LevelRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	for j := 1; j < 100; j++ {
+		if j%7 == 0 {
+			continue
+		}
+		if err := diskDB.Put(Key(1), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		time       ValidTime
+		seq        SequenceNumber
+		status     Status
+		start, end ValidTime
+	}{
+		{5, 0, NOTFOUND, 0, 10},
+		{10, 1, OK, 10, 20},
+		{65, 6, HOLE, 60, 80},
+		{995, 99, ODV, 990, OpenEnd},
+		{5000, 99, ODV, 990, OpenEnd},
+	} {
+		result, status, _, err := diskDB.GetInterval(Key(1), c.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != c.status || result.Start() != c.start || result.End() != c.end {
+			t.Fatalf("GetInterval(1, %d) = %s [%d, %d), want %s [%d, %d)", c.time, status, result.Start(), result.End(), c.status, c.start, c.end)
+		}
+		if (result.Message() == nil) != (c.seq == 0) || (result.Message() != nil && result.Message().SequenceNumber() != c.seq) {
+			t.Fatalf("GetInterval(1, %d) = %v, want version %d", c.time, result.Message(), c.seq)
+		}
+	}
+
+	// the version is valid throughout its interval, and only there
+	for time := ValidTime(10); time < 1000; time += 5 {
+		result, _, _, _ := diskDB.GetInterval(Key(1), time)
+		for _, probe := range []ValidTime{result.Start(), result.End() - 1} {
+			message, _, _, _ := diskDB.Get(Key(1), probe)
+			if message != *result.Message() {
+				t.Fatalf("Get(1, %d) = %s, want %s", probe, message, result.Message())
+			}
+		}
+		if message, _, _, _ := diskDB.Get(Key(1), result.End()); result.End() != OpenEnd && message == *result.Message() {
+			t.Fatalf("Get(1, %d) = %s after the end of its interval", result.End(), message)
+		}
+	}
+}
+
+func TestOverlappingInterval(t *testing.T) {
+	results := map[Key]ResultWithInterval{
+		1: {start: 10, end: 50},
+		2: {start: 20, end: OpenEnd},
+		3: {start: 0, end: 40},
+	}
+	if lo, hi, ok := OverlappingInterval(results); !ok || lo != 20 || hi != 40 {
+		t.Fatalf("OverlappingInterval = [%d, %d) %t, want [20, 40) true", lo, hi, ok)
+	}
+	// intervals are half-open, so touching intervals do not overlap
+	results[4] = ResultWithInterval{start: 40, end: 60}
+	if lo, hi, ok := OverlappingInterval(results); ok {
+		t.Fatalf("OverlappingInterval = [%d, %d) true, want false", lo, hi)
+	}
+}
+
+func TestDB_GetConsistent(t *testing.T) {
+	db, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j < 10; j++ {
+		if err := db.Put(1, SequenceNumber(j), ValidTime(j*10), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(2, SequenceNumber(j), ValidTime(j*10+5), ""); err != nil {
+			t.Fatal(err)
+		}
+		if j != 5 {
+			// key 3 misses version 5
+			if err := db.Put(3, SequenceNumber(j), ValidTime(j*10), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	results, lo, hi, err := db.GetConsistent([]Key{1, 2}, 37)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, r2 := results[1], results[2]
+	if lo != 35 || hi != 40 || r1.Message().SequenceNumber() != 3 || r2.Message().SequenceNumber() != 3 {
+		t.Fatalf("GetConsistent([1 2], 37) = [%d, %d), want [35, 40) with version 3 of both keys", lo, hi)
+	}
+	if _, lo, hi, err = db.GetConsistent([]Key{1, 2}, 1000); err != nil || lo != 95 || hi != OpenEnd {
+		t.Fatalf("GetConsistent([1 2], 1000) = [%d, %d) %v, want [95, OpenEnd)", lo, hi, err)
+	}
+
+	// the HOLE version of key 3 is only known to be valid at its creation time
+	if _, lo, hi, err = db.GetConsistent([]Key{1, 3}, 40); err != nil || lo != 40 || hi != 41 {
+		t.Fatalf("GetConsistent([1 3], 40) = [%d, %d) %v, want [40, 41)", lo, hi, err)
+	}
+	for _, c := range []struct {
+		keys []Key
+		time ValidTime
+		key  Key
+	}{
+		{[]Key{1, 3}, 45, 3},
+		{[]Key{2, 1}, 12, 2},
+		{[]Key{1, 4}, 50, 4},
+	} {
+		_, _, _, err := db.GetConsistent(c.keys, c.time)
+		inconsistent, ok := err.(InconsistentResults)
+		if !ok || inconsistent.Key() != c.key {
+			t.Fatalf("GetConsistent(%v, %d) = %v, want an inconsistency at key %d", c.keys, c.time, err, c.key)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"math"
+	"sort"
+)
+
+// OpenEnd is the end of validity of a version that has no successor yet.
+const OpenEnd = ValidTime(math.MaxUint64)
+
+// VersionIterator iterates over the versions of a key whose validity overlaps a valid-time range,
+// in valid-time order. A version is valid from its creation time until the creation time of the next stored
+// version, or OpenEnd if it is the latest one.
+//
+// The versions are read when the iterator is created, so later writes are not observed.
+type VersionIterator struct {
+	versions []Message
+	spans    seqSpans
+	hi       ValidTime
+	i        int // the position of the next version to yield
+
+	message      Message
+	end          ValidTime
+	status       Status
+	nextSequence SequenceNumber
+	err          error
+}
+
+// NewVersionIterator returns an iterator over the versions of key valid at some time in [lo, hi].
+// Each version comes with its end of validity and the status Get reports for it.
+// The range is clipped to the retention horizon of the key.
+func (db *DB) NewVersionIterator(key Key, lo, hi ValidTime) *VersionIterator {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	it := &VersionIterator{hi: hi, spans: db.spans(key)}
+	if db.Quarantined(key) {
+		it.err = KeyQuarantined{key}
+		return it
+	}
+	var deleted seqSpans
+	it.versions, deleted, it.err = db.versions(key)
+	if horizon := db.horizon(key, db.mem.latest()); lo < horizon {
+		// the versions valid only below the horizon may have been dropped
+		lo = horizon
+	}
+	it.spans = it.spans.union(deleted)
+	if db.opts.OutOfOrderPolicy == ResortOutOfOrder {
+		it.versions = resortVersions(it.versions)
+	}
+	// start at the version valid at lo
+	it.i = sort.Search(len(it.versions), func(i int) bool { return it.versions[i].creationTime > lo })
+	if it.i > 0 {
+		it.i--
+	}
+	return it
+}
+
+// versions returns the versions of key that have not been deleted, with their amended values,
+// and the sequence numbers of the deleted ones.
+func (db *DB) versions(key Key) (versions []Message, deleted seqSpans, err error) {
+	records, err := db.records(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions = records[:0]
+	for _, record := range records {
+		if record.kind == tombstoneKind {
+			deleted = deleted.add(record.sequenceNumber)
+			continue
+		}
+		versions = append(versions, record)
+	}
+	return versions, deleted, nil
+}
+
+// records merges the records of key held by every component. If two components hold a record of the same version,
+// the one from the newer component is kept, so that tombstones and amendments shadow the versions they apply to.
+func (db *DB) records(key Key) ([]Message, error) {
+	var all []Message
+	for _, c := range db.components() {
+		versions, err := c.versions(key)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, versions...)
+	}
+	// the sort is stable, so the first of equal versions comes from the newest component
+	sort.SliceStable(all, func(i, j int) bool { return compareVersions(all[i], all[j]) < 0 })
+	result := all[:0]
+	for _, version := range all {
+		if n := len(result); n > 0 && compareVersions(result[n-1], version) == 0 {
+			continue
+		}
+		result = append(result, version)
+	}
+	return result, nil
+}
+
+// Next advances the iterator. It returns false when the range is exhausted or an error occurs.
+func (it *VersionIterator) Next() bool {
+	for it.err == nil && it.i < len(it.versions) && it.versions[it.i].creationTime <= it.hi {
+		message := &it.versions[it.i]
+		var next *Message
+		it.end = OpenEnd
+		if it.i+1 < len(it.versions) {
+			next = &it.versions[it.i+1]
+			it.end = next.creationTime
+		}
+		it.i++
+		if it.end == message.creationTime {
+			// the successor shares the creation time, so the version is never valid
+			continue
+		}
+		it.message, it.status, it.nextSequence, it.err = resolve(message, next, it.spans)
+		return it.err == nil
+	}
+	return false
+}
+
+func (it *VersionIterator) Message() Message {
+	return it.message
+}
+
+// End returns the end of validity of the current version: the version is valid in [CreationTime, End).
+func (it *VersionIterator) End() ValidTime {
+	return it.end
+}
+
+func (it *VersionIterator) Status() Status {
+	return it.status
+}
+
+// NextSequence returns the sequence number of the version succeeding the current one, as reported by Get.
+func (it *VersionIterator) NextSequence() SequenceNumber {
+	return it.nextSequence
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *VersionIterator) Err() error {
+	return it.err
+}
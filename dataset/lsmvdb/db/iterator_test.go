@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_VersionIterator(t *testing.T) {
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 512, LevelRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	for j := 1; j < 100; j++ {
+		for k := 1; k < 4; k++ {
+			if j%7 == 0 {
+				continue
+			}
+			if err := diskDB.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// two versions sharing a creation time: only the latter is ever valid
+	if err := diskDB.Put(Key(2), 100, 995, "value 100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := diskDB.Put(Key(2), 101, 995, "value 101"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ lo, hi ValidTime }{{0, 1000}, {5, 5}, {15, 15}, {333, 555}, {990, 2000}} {
+		for k := 1; k < 4; k++ {
+			it := diskDB.NewVersionIterator(Key(k), r.lo, r.hi)
+			var yielded []*VersionIterator
+			for it.Next() {
+				current := *it
+				yielded = append(yielded, &current)
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			// every tick of the range is covered by the version Get returns, with the same status
+			for time := r.lo; time <= r.hi; time++ {
+				message, status, nextSequence, _ := diskDB.Get(Key(k), time)
+				var covering *VersionIterator
+				for _, v := range yielded {
+					if v.Message().CreationTime() <= time && time < v.End() {
+						if covering != nil {
+							t.Fatalf("key %d, time %d: covered by two versions", k, time)
+						}
+						covering = v
+					}
+				}
+				if status == NOTFOUND {
+					if covering != nil {
+						t.Fatalf("key %d, time %d: covered by %s, want none", k, time, covering.Message())
+					}
+					continue
+				}
+				if covering == nil || covering.Message() != message || covering.Status() != status || covering.NextSequence() != nextSequence {
+					t.Fatalf("key %d, time %d: Get = %s %s %d, not covered by the iterator", k, time, message, status, nextSequence)
+				}
+			}
+		}
+	}
+
+	it := diskDB.NewVersionIterator(Key(2), 990, 2000)
+	var statuses []Status
+	for it.Next() {
+		statuses = append(statuses, it.Status())
+	}
+	if len(statuses) != 2 || statuses[0] != OK || statuses[1] != ODV || it.End() != OpenEnd {
+		t.Fatalf("statuses = %v, end = %d, want [OK ODV] and an open end", statuses, it.End())
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const manifestFileName = "MANIFEST"
+
+// manifest describes the persistent state of a database. It is rewritten as a whole whenever
+// the set of live files changes: a new version is written to a temporary file, synced and renamed
+// over the previous one, so a crash leaves either the old or the new manifest behind.
+type manifest struct {
+	NextFileNumber uint64 `json:"next_file_number"`
+	// LogNumber is the oldest write-ahead log whose entries have not been flushed to a table.
+	// Every log with a smaller number is obsolete.
+	LogNumber uint64 `json:"log_number"`
+	// ArchiveTime is the archive time of the latest flushed memtable.
+	ArchiveTime ValidTime `json:"archive_time"`
+	// Levels lists the numbers of the live tables of each level, oldest first.
+	Levels [][]uint64 `json:"levels"`
+	// Quarantined lists the keys quarantined by the QuarantineOutOfOrder policy.
+	Quarantined []Key `json:"quarantined,omitempty"`
+	// Retention holds the retention of the keys set by SetRetention.
+	Retention map[Key]ValidTime `json:"retention,omitempty"`
+}
+
+func readManifest(filename string) (m manifest, exist bool, err error) {
+	buf, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{}, false, nil
+	} else if err != nil {
+		return manifest{}, false, err
+	}
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return manifest{}, false, CorruptedFile{filename, err.Error()}
+	}
+	return m, true, nil
+}
+
+// writeManifest persists the current state of the database.
+func (db *DB) writeManifest() error {
+	m := manifest{
+		NextFileNumber: db.nextFileNumber,
+		LogNumber:      db.mem.logNumber,
+		ArchiveTime:    db.archiveTime,
+		Levels:         make([][]uint64, len(db.levels)),
+		Retention:      db.retention,
+	}
+	if len(db.imm) > 0 {
+		m.LogNumber = db.imm[0].logNumber
+	}
+	for key := range db.quarantined {
+		m.Quarantined = append(m.Quarantined, key)
+	}
+	sort.Slice(m.Quarantined, func(i, j int) bool { return m.Quarantined[i] < m.Quarantined[j] })
+	for i, level := range db.levels {
+		m.Levels[i] = make([]uint64, len(level))
+		for j, t := range level {
+			m.Levels[i][j] = t.number
+		}
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(db.path, manifestFileName)
+	if err = writeFileSync(filename+".tmp", buf); err != nil {
+		return err
+	}
+	if err = os.Rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+	return syncDir(db.path)
+}
+
+// recover rebuilds the state of the database from the manifest: it opens the live tables, replays the
+// write-ahead logs that have not been flushed into the memtable, flushes them and starts a new log.
+// Files left behind by an interrupted flush or compaction are deleted.
+func (db *DB) recover() error {
+	m, exist, err := readManifest(filepath.Join(db.path, manifestFileName))
+	if err != nil {
+		return err
+	}
+	if exist {
+		db.nextFileNumber = m.NextFileNumber
+		db.archiveTime = m.ArchiveTime
+		db.mem = NewMemtable(m.ArchiveTime)
+		for _, key := range m.Quarantined {
+			db.quarantined[key] = true
+		}
+		for key, retention := range m.Retention {
+			db.retention[key] = retention
+		}
+	}
+	db.levels = make([][]*table, len(m.Levels))
+	for i, numbers := range m.Levels {
+		for _, number := range numbers {
+			t, err := openTable(db.fileName(number, "sst"), number)
+			if err != nil {
+				return err
+			}
+			db.levels[i] = append(db.levels[i], t)
+		}
+	}
+
+	entries, err := os.ReadDir(db.path)
+	if err != nil {
+		return err
+	}
+	var logs []uint64
+	for _, entry := range entries {
+		number, ext, ok := parseFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		if number >= db.nextFileNumber {
+			db.nextFileNumber = number + 1
+		}
+		if ext == "log" && number >= m.LogNumber {
+			logs = append(logs, number)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	for _, number := range logs {
+		if err = replayWAL(db.fileName(number, "log"), db.mem.add); err != nil {
+			return err
+		}
+	}
+	if len(logs) > 0 {
+		db.mem.logNumber = logs[0]
+	}
+
+	if len(db.mem.data) > 0 {
+		if err = db.rotate(); err != nil {
+			return err
+		}
+		// flush persists a manifest which makes the replayed logs obsolete
+		return db.flush()
+	}
+	if err = db.newLog(); err != nil {
+		return err
+	}
+	if err = db.writeManifest(); err != nil {
+		return err
+	}
+	return db.removeObsoleteFiles()
+}
+
+// removeObsoleteFiles deletes the logs preceding the manifest log number, the tables that are not live
+// and an unfinished manifest.
+func (db *DB) removeObsoleteFiles() error {
+	live := make(map[uint64]bool)
+	for _, level := range db.levels {
+		for _, t := range level {
+			live[t.number] = true
+		}
+	}
+	logNumber := db.mem.logNumber
+	if len(db.imm) > 0 {
+		logNumber = db.imm[0].logNumber
+	}
+
+	entries, err := os.ReadDir(db.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		obsolete := entry.Name() == manifestFileName+".tmp"
+		if number, ext, ok := parseFileName(entry.Name()); ok {
+			obsolete = (ext == "log" && number < logNumber) || (ext == "sst" && !live[number])
+		}
+		if obsolete {
+			if err = os.Remove(filepath.Join(db.path, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileSync writes data to a new file and syncs it to stable storage.
+func writeFileSync(filename string, data []byte) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir makes the creation, renaming and deletion of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDB_CrashRecovery(t *testing.T) {
+	points := []string{"flush: table written", "flush: manifest written", "compaction: table written", "compaction: manifest written"}
+	for _, point := range points {
+		t.Run(point, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := &Options{MemtableSize: 512, LevelRuns: 2}
+			memDB, err := Open("", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			diskDB, err := Open(dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errCrash := errors.New("simulated crash")
+			hits := 0
+			diskDB.crashHook = func(p string) error {
+				if p == point {
+					hits++
+					if hits == 3 {
+						return errCrash
+					}
+				}
+				return nil
+			}
+
+		loop:
+			for j := 1; j < 100; j++ {
+				for k := 1; k < 10; k++ {
+					if j%7 == 0 {
+						continue
+					}
+					// the version is in the log once Put reaches a flush, so it survives the crash
+					if err := memDB.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+						t.Fatal(err)
+					}
+					err := diskDB.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j))
+					if errors.Is(err, errCrash) {
+						break loop
+					} else if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if hits < 3 {
+				t.Fatalf("crash point %q was reached %d times", point, hits)
+			}
+
+			// the crashed database is abandoned without closing it
+			recovered, err := Open(dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer recovered.Close()
+			compareGets(t, memDB, recovered)
+			if recovered.mem.creationTime != recovered.archiveTime {
+				t.Fatalf("memtable created at %d, want the archive time %d", recovered.mem.creationTime, recovered.archiveTime)
+			}
+		})
+	}
+}
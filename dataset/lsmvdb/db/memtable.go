@@ -1,84 +1,266 @@
 package db
 
-import "github.com/MauriceGit/skiplist"
+import (
+	"math"
+	"sort"
+	"sync"
+)
 
+const (
+	// messageOverhead approximates the memory used by a version besides its value.
+	messageOverhead = 48
+	// memtableStripes is the number of locks the keys of a memtable are spread over.
+	memtableStripes = 64
+)
+
+// Memtable holds the latest versions in memory. It is safe for concurrent use: mu guards the map of keys and
+// the statistics, while the version list of each key is guarded by one of the striped locks, so that writers
+// of different keys rarely wait for each other.
 type Memtable struct {
+	mu    sync.RWMutex
+	locks [memtableStripes]sync.RWMutex
+
 	creationTime ValidTime
 	archiveTime  ValidTime
+	logNumber    uint64    // the write-ahead log holding the entries of the memtable
+	size         int       // approximate memory usage in bytes
+	maxTime      ValidTime // the latest creation time in the memtable
 	// data is map of data streams indexed by sensor keys.
-	// each data stream is also organized as a map indexed by the lower valid times of individual data versions.
-	data map[Key]*skiplist.SkipList
+	// each data stream is a skiplist of versions ordered by creation time and sequence number.
+	data map[Key]*SkipList[Message]
 }
 
 func NewMemtable(creationTime ValidTime) *Memtable {
-	data := make(map[Key]*skiplist.SkipList)
+	data := make(map[Key]*SkipList[Message])
 	return &Memtable{
 		creationTime: creationTime,
+		maxTime:      creationTime,
 		data:         data,
 	}
 }
 
-// archiveMemtable TODO: implement the following functions
-// minorCompact
+// minorCompact writes the archived memtable mem to a table file. The versions of a key whose validity ends at or
+// before horizon(key) are dropped, but their sequence numbers are recorded.
+func minorCompact(mem *Memtable, filename string, blockSize int, horizon func(Key) ValidTime) error {
+	w, err := newTableWriter(filename, blockSize, 0)
+	if err != nil {
+		return err
+	}
+	for _, key := range mem.keys() {
+		versions, _ := mem.versions(key) // the memtable never fails
+		versions, dropped := expire(versions, horizon(key))
+		w.AddSpans(key, dropped)
+		for _, version := range versions {
+			if err = w.Add(key, version); err != nil {
+				w.Abort()
+				return err
+			}
+		}
+	}
+	return w.Finish()
+}
+
+// archiveMemtable marks the memtable as immutable as of archiveTime.
+// An archived memtable is no longer written and waits to be flushed by minorCompact.
 func archiveMemtable(memtable *Memtable, archiveTime ValidTime) {
 	memtable.archiveTime = archiveTime
 }
 
-func (mem Memtable) Put(key Key, sequenceNumber SequenceNumber, creationTime ValidTime, value string) (err error) {
-	err = nil
+// lockFor returns the lock guarding the version list of key.
+func (mem *Memtable) lockFor(key Key) *sync.RWMutex {
+	return &mem.locks[key%memtableStripes]
+}
+
+// list returns the version list of key, creating it if create is set.
+func (mem *Memtable) list(key Key, create bool) *SkipList[Message] {
+	mem.mu.RLock()
 	list, exist := mem.data[key]
-	if exist != true {
-		newVersionList := skiplist.New()
-		mem.data[key] = &newVersionList
-		list = mem.data[key]
+	mem.mu.RUnlock()
+	if exist || !create {
+		return list
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if list, exist = mem.data[key]; !exist {
+		list = NewSkipList(compareVersions)
+		mem.data[key] = list
 	}
-	list.Insert(Message{sequenceNumber: sequenceNumber, creationTime: creationTime, value: value})
+	return list
+}
+
+func (mem *Memtable) Put(key Key, sequenceNumber SequenceNumber, creationTime ValidTime, value string) (err error) {
+	return mem.PutAt(key, sequenceNumber, creationTime, 0, value)
+}
 
-	return
+// PutAt is like Put, but records the arrival time of the version.
+func (mem *Memtable) PutAt(key Key, sequenceNumber SequenceNumber, creationTime, arrivalTime ValidTime, value string) (err error) {
+	return mem.add(key, Message{sequenceNumber: sequenceNumber, creationTime: creationTime, value: value, arrivalTime: arrivalTime})
+}
+
+// add inserts a record of any kind into the memtable, replacing the record of the same version.
+func (mem *Memtable) add(key Key, message Message) error {
+	list := mem.list(key, true)
+	lock := mem.lockFor(key)
+	lock.Lock()
+	replaced := list.Insert(message)
+	lock.Unlock()
+
+	mem.mu.Lock()
+	if !replaced {
+		mem.size += messageOverhead
+	}
+	mem.size += len(message.value)
+	if message.creationTime > mem.maxTime {
+		mem.maxTime = message.creationTime
+	}
+	mem.mu.Unlock()
+
+	return nil
+}
+
+// latest returns the latest creation time written to the memtable or, if it is empty, to the memtables before it.
+func (mem *Memtable) latest() ValidTime {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	return mem.maxTime
+}
+
+// full reports whether the memtable has grown beyond size bytes or beyond timeSpan past its creation time.
+// A timeSpan of 0 disables the latter threshold.
+func (mem *Memtable) full(size int, timeSpan ValidTime) bool {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	if mem.size >= size {
+		return true
+	}
+	return timeSpan > 0 && mem.maxTime >= mem.creationTime+timeSpan
 }
 
 // Get function returns the message body, the status and the sequence number of the next message to a query.
 // the returned status
-func (mem Memtable) Get(key Key, time ValidTime) (message Message, status Status, nextSequence SequenceNumber, err error) {
-	err = nil
-	list, exist := mem.data[key]
-	if exist != true {
-		return Message{}, Status(NOTFOUND), 0, nil
-	}
-	elem, ok := list.FindGreaterOrEqual(Message{creationTime: time})
-	if ok {
-		message := elem.GetValue().(Message)
-		var next Message
-		if time == message.creationTime {
-			// correct version
-			if elem == list.GetLargestNode() {
-				// this is the last version
-				return message, Status(ODV), 0, nil
-			}
-			next = list.Next(elem).GetValue().(Message)
-		} else {
-			if elem == list.GetSmallestNode() {
-				// this is the first version, and it has not been generated at time.
-				return Message{}, Status(NOTFOUND), message.sequenceNumber, nil
-			}
-			// go backward
-			next = message
-			message = list.Prev(elem).GetValue().(Message)
-		}
-		nextSequence = next.sequenceNumber
-		// Check the sequence number of the successive version
-		if nextSequence == message.sequenceNumber+1 {
-			// non-ODV. status set to OK
-			return message, Status(OK), nextSequence, nil
-		} else if nextSequence > message.sequenceNumber+1 {
-			// HOLE
-			return message, Status(HOLE), nextSequence, nil
-		} else {
-			// nextSequence <= message.sequenceNumber
-			return Message{}, Status(ERROR), nextSequence, SequenceOutOfOrder{message.sequenceNumber, nextSequence}
+func (mem *Memtable) Get(key Key, time ValidTime) (message Message, status Status, nextSequence SequenceNumber, err error) {
+	found, next, _ := mem.lookup(key, time)
+	return resolve(found, next, nil)
+}
+
+// lookup returns the version of key valid at time, i.e., the last version created at or before time,
+// and the version succeeding it. Either is nil if it does not exist in the memtable.
+// The memtable never fails a lookup; the error is there to implement component.
+func (mem *Memtable) lookup(key Key, time ValidTime) (message, next *Message, err error) {
+	list := mem.list(key, false)
+	if list == nil {
+		return nil, nil, nil
+	}
+	lock := mem.lockFor(key)
+	lock.RLock()
+	defer lock.RUnlock()
+	// the first version created after time. Only a version carrying the largest sequence number
+	// can compare equal to the probe, and it is created at time.
+	elem := list.Seek(Message{creationTime: time, sequenceNumber: math.MaxUint64})
+	if elem != nil && elem.Value().creationTime == time {
+		elem = elem.Next()
+	}
+	var found *SkipListNode[Message]
+	if elem == nil {
+		found = list.Last()
+	} else {
+		found = elem.Prev()
+		successor := elem.Value()
+		next = &successor
+	}
+	if found != nil {
+		version := found.Value()
+		message = &version
+	}
+	return message, next, nil
+}
+
+// keys returns the keys in the memtable in ascending order.
+func (mem *Memtable) keys() []Key {
+	mem.mu.RLock()
+	keys := make([]Key, 0, len(mem.data))
+	for key := range mem.data {
+		keys = append(keys, key)
+	}
+	mem.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// versions returns the versions of key in the order of their creation times.
+// Like lookup, it never fails.
+func (mem *Memtable) versions(key Key) ([]Message, error) {
+	list := mem.list(key, false)
+	if list == nil {
+		return nil, nil
+	}
+	lock := mem.lockFor(key)
+	lock.RLock()
+	defer lock.RUnlock()
+	versions := make([]Message, 0, list.Len())
+	for elem := list.First(); elem != nil; elem = elem.Next() {
+		versions = append(versions, elem.Value())
+	}
+	return versions, nil
+}
+
+// resolve classifies the version message found for a query against its successor next.
+// It implements the Get semantics shared by the memtable and the database:
+//   - NOTFOUND: no version was created at the requested time. nextSequence is the sequence number of the first version, if any.
+//   - ODV: the version is the latest one, so it may be outdated.
+//   - OK: the successor immediately follows the version, so the version is confirmed.
+//   - HOLE: some versions between the version and its successor have not arrived yet.
+//
+// spans holds the sequence numbers known to have been written to the key. A version whose immediate successor
+// is covered by spans is OK even if the successor itself has been dropped by a compaction.
+func resolve(message, next *Message, spans seqSpans) (Message, Status, SequenceNumber, error) {
+	if message == nil {
+		if next == nil {
+			return Message{}, Status(NOTFOUND), 0, nil
 		}
+		// this is the first version, and it has not been generated at time.
+		return Message{}, Status(NOTFOUND), next.sequenceNumber, nil
+	}
+	if spans.contains(message.sequenceNumber+1) && (next == nil || next.sequenceNumber > message.sequenceNumber) {
+		// the immediate successor has been written but dropped
+		return *message, Status(OK), message.sequenceNumber + 1, nil
+	}
+	if next == nil {
+		// this is the last version
+		return *message, Status(ODV), 0, nil
+	}
+	nextSequence := next.sequenceNumber
+	// Check the sequence number of the successive version
+	if nextSequence == message.sequenceNumber+1 {
+		// non-ODV. status set to OK
+		return *message, Status(OK), nextSequence, nil
+	} else if nextSequence > message.sequenceNumber+1 {
+		// HOLE
+		return *message, Status(HOLE), nextSequence, nil
 	} else {
-		// matches last version
-		return list.GetLargestNode().GetValue().(Message), Status(ODV), 0, nil
+		// nextSequence <= message.sequenceNumber
+		return Message{}, Status(ERROR), nextSequence, SequenceOutOfOrder{message.sequenceNumber, nextSequence}
+	}
+}
+
+// Resolve classifies the version message valid at the time of a query against its successor next,
+// as Get does. It lets other engines share the classification of the database.
+func Resolve(message, next *Message) (Message, Status, SequenceNumber, error) {
+	return resolve(message, next, nil)
+}
+
+// compareVersions orders two versions of the same key by creation time, then by sequence number.
+func compareVersions(a, b Message) int {
+	switch {
+	case a.creationTime < b.creationTime:
+		return -1
+	case a.creationTime > b.creationTime:
+		return 1
+	case a.sequenceNumber < b.sequenceNumber:
+		return -1
+	case a.sequenceNumber > b.sequenceNumber:
+		return 1
+	default:
+		return 0
 	}
 }
@@ -0,0 +1,67 @@
+package db
+
+import "math"
+
+// Options controls the behaviour of a DB. The zero value of a field selects its default.
+type Options struct {
+	// MemtableSize is the approximate number of bytes the active memtable holds
+	// before it becomes immutable and is flushed to a table.
+	MemtableSize int
+	// MemtableTimeSpan is the valid-time span the active memtable covers before it is flushed,
+	// measured from its creation time to the latest creation time it holds. 0 disables the threshold.
+	MemtableTimeSpan ValidTime
+	// BlockSize is the approximate size of a data block in a table file.
+	BlockSize int
+	// LevelRuns is the number of tables a level holds before they are merged into a table of the next level.
+	LevelRuns int
+	// MaxVersionsPerKey is the number of the newest versions of a key kept by a compaction into the bottom level,
+	// which drops the oldest versions of the key. 0 keeps every version.
+	MaxVersionsPerKey int
+	// QueryDeadline is the time a query issued by Query waits for its final results after its arrival,
+	// unless QueryOptions.Deadline overrides it. The default lets it wait until they are final.
+	QueryDeadline ValidTime
+	// QueryCorrectness is the probability of temporal correctness at which a query issued by Query completes
+	// before its results are final, unless QueryOptions.Correctness overrides it. The default is 1.
+	QueryCorrectness float64
+	// RetainQueries keeps the completed queries issued by Query until ForgetQueries drops them, so that Delete and
+	// Amend flag those that returned the version they correct as Retracted. By default they are dropped at once.
+	RetainQueries bool
+	// AssignSequenceNumbers makes Put and PutAt assign the sequence number of a version written with
+	// sequence number 0, as Append does. Versions written with a nonzero sequence number are stored as given.
+	AssignSequenceNumbers bool
+	// OutOfOrderPolicy selects how a version whose sequence number contradicts its creation time is handled.
+	// The default rejects such writes.
+	OutOfOrderPolicy OutOfOrderPolicy
+	// Retention is the valid-time span of history kept for every key, unless overridden by SetRetention.
+	// 0 keeps every version.
+	Retention ValidTime
+}
+
+const (
+	defaultMemtableSize = 4 << 20
+	defaultLevelRuns    = 4
+)
+
+// withDefaults returns a copy of the options with every unset field replaced by its default.
+func (o *Options) withDefaults() *Options {
+	opts := Options{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = defaultMemtableSize
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultBlockSize
+	}
+	if opts.LevelRuns < 2 {
+		opts.LevelRuns = defaultLevelRuns
+	}
+	if opts.QueryDeadline == 0 {
+		opts.QueryDeadline = math.MaxUint64
+	}
+	if opts.QueryCorrectness <= 0 {
+		opts.QueryCorrectness = 1
+	}
+	return &opts
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// OutOfOrderPolicy selects how a version whose sequence number contradicts its creation time is handled,
+// i.e., a version created after a version with a larger sequence number, or before one with a smaller sequence number.
+// The policy is applied the same way by DB writes and reads and by the updates of a QueryPool.
+type OutOfOrderPolicy int8
+
+const (
+	// RejectOutOfOrder rejects an out-of-order write with SequenceOutOfOrder. A query pool ignores the message.
+	// Reads of out-of-order versions written before fail with SequenceOutOfOrder, as they always did.
+	RejectOutOfOrder OutOfOrderPolicy = iota
+	// ResortOutOfOrder accepts an out-of-order write, but moves its creation time into its position in the sequence:
+	// it is clamped between the creation times of the versions preceding and succeeding it in sequence.
+	// Reads re-sort the versions of a key by sequence number, raising the creation time of every version to the one
+	// of its predecessor, when the version found is out of order with its neighbours, e.g., versions written under
+	// another policy. A query pool ignores the message, since it is moved before the current one.
+	// The moved version is stored with its new creation time only: the one it was written with is lost, and neither
+	// the reads nor a database reopened under another policy can tell it apart from a version written in order.
+	ResortOutOfOrder
+	// QuarantineOutOfOrder accepts an out-of-order write as is and quarantines its key: reads of the key fail with
+	// KeyQuarantined and status QUARANTINED until the key is released. A query pool completes the queries of the key
+	// with the reason Quarantined.
+	QuarantineOutOfOrder
+)
+
+// admit checks a new version of key against the stored versions before it is written, and returns the creation
+// time to store it with, following the rules of Admit. The version of a quarantined key is admitted, and a version
+// with the sequence number of one dropped by a compaction along with its tombstone, if any, is a duplicate.
+// Duplicates and conflicts are counted in Stats.
+// The caller holds the key lock of key.
+func (db *DB) admit(key Key, sequenceNumber SequenceNumber, creationTime ValidTime, value Value) (_ ValidTime, duplicate bool, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	prev, next, err := db.neighbours(key, sequenceNumber, creationTime)
+	if err != nil {
+		return 0, false, err
+	}
+	records := func() ([]Message, error) { return db.records(key) }
+	creationTime, duplicate, err = admitVersion(key, prev, next, records, db.spans(key), sequenceNumber, creationTime, value, db.opts.OutOfOrderPolicy)
+	switch err.(type) {
+	case ConflictingVersion:
+		atomic.AddUint64(&db.stats.Conflicts, 1)
+	case KeyQuarantined:
+		return creationTime, false, db.quarantine(key)
+	}
+	if duplicate {
+		atomic.AddUint64(&db.stats.Duplicates, 1)
+	}
+	return creationTime, duplicate, err
+}
+
+// Admit checks a new version of key against its stored versions as Put does under policy, and returns the creation
+// time to store the version with. prev and next are the stored versions preceding and succeeding the new one in the
+// order of creation time and sequence number, where a stored version with the same creation time and sequence number
+// is passed as prev. versions returns every stored version of the key, and is only called if prev or next is out of
+// order with the new version. Admit lets other engines share the handling of repeated and out-of-order versions
+// of the database:
+//   - A version repeating a stored one with the same sequence number, creation time and value is a duplicate,
+//     which is not written again. So is a retried write of a deleted or amended version, which does not restore
+//     its original value.
+//   - A different version with the same sequence number fails with ConflictingVersion, wherever its creation time
+//     puts it.
+//   - An out-of-order version fails with SequenceOutOfOrder under RejectOutOfOrder, and is moved between its
+//     neighbours in sequence under ResortOutOfOrder. Under QuarantineOutOfOrder, it is admitted with the error
+//     KeyQuarantined, which the engine reports for the key from then on.
+func Admit(key Key, prev, next *Message, versions func() []Message, sequenceNumber SequenceNumber, creationTime ValidTime,
+	value Value, policy OutOfOrderPolicy) (_ ValidTime, duplicate bool, err error) {
+	records := func() ([]Message, error) { return versions(), nil }
+	return admitVersion(key, prev, next, records, nil, sequenceNumber, creationTime, value, policy)
+}
+
+// admitVersion implements Admit given the neighbours prev and next of the new version in creation-time order.
+// records returns every stored record of the key, and is only called if the neighbours are out of order with the new
+// version. written holds sequence numbers written to the key, but no longer stored.
+func admitVersion(key Key, prev, next *Message, records func() ([]Message, error), written seqSpans,
+	sequenceNumber SequenceNumber, creationTime ValidTime, value Value, policy OutOfOrderPolicy) (_ ValidTime, duplicate bool, err error) {
+	// a stored version with the same sequence number is adjacent to the new one if it was created at the same time
+	for _, version := range []*Message{prev, next} {
+		if version != nil && version.sequenceNumber == sequenceNumber {
+			return repeat(key, *version, creationTime, value, policy)
+		}
+	}
+	if written.contains(sequenceNumber) {
+		return creationTime, true, nil
+	}
+	ordered := (prev == nil || prev.sequenceNumber < sequenceNumber) && (next == nil || next.sequenceNumber > sequenceNumber)
+	// the stored versions of a key are ordered unless the QuarantineOutOfOrder policy admitted an out-of-order one,
+	// so a stored version with the same sequence number created at another time makes the new one out of order
+	var all []Message
+	if !ordered || policy == QuarantineOutOfOrder {
+		if all, err = records(); err != nil {
+			return 0, false, err
+		}
+		for _, record := range all {
+			if record.sequenceNumber == sequenceNumber {
+				return repeat(key, record, creationTime, value, policy)
+			}
+		}
+	}
+	if ordered {
+		return creationTime, false, nil
+	}
+
+	switch policy {
+	case ResortOutOfOrder:
+		for _, version := range all {
+			if version.kind != tombstoneKind && version.sequenceNumber < sequenceNumber && version.creationTime > creationTime {
+				creationTime = version.creationTime
+			}
+		}
+		for _, version := range all {
+			if version.kind != tombstoneKind && version.sequenceNumber > sequenceNumber && version.creationTime < creationTime {
+				creationTime = version.creationTime
+			}
+		}
+		return creationTime, false, nil
+	case QuarantineOutOfOrder:
+		return creationTime, false, KeyQuarantined{key}
+	default:
+		if prev != nil && prev.sequenceNumber > sequenceNumber {
+			return 0, false, SequenceOutOfOrder{prev.sequenceNumber, sequenceNumber}
+		}
+		return 0, false, SequenceOutOfOrder{sequenceNumber, next.sequenceNumber}
+	}
+}
+
+// repeat checks a new version of key against the stored record of the version with the same sequence number.
+// Under the ResortOutOfOrder policy, the stored version may have been moved to another creation time, so only
+// the values are compared.
+func repeat(key Key, stored Message, creationTime ValidTime, value Value, policy OutOfOrderPolicy) (_ ValidTime, duplicate bool, err error) {
+	moved := policy == ResortOutOfOrder
+	if (stored.creationTime == creationTime || moved) && (stored.Payload() == value || stored.kind != valueKind) {
+		return stored.creationTime, true, nil
+	}
+	return 0, false, ConflictingVersion{key, stored.sequenceNumber}
+}
+
+// neighbours returns the versions of key preceding and succeeding a new version in creation-time order.
+func (db *DB) neighbours(key Key, sequenceNumber SequenceNumber, creationTime ValidTime) (prev, next *Message, err error) {
+	prev, next, err = db.lookup(key, creationTime)
+	if err != nil || prev == nil || prev.creationTime != creationTime || prev.sequenceNumber <= sequenceNumber {
+		return prev, next, err
+	}
+	// the new version slots in among the versions created at the same time
+	next = prev
+	prev = nil
+	if creationTime > 0 {
+		prev, _, err = db.lookup(key, creationTime-1)
+	}
+	return prev, next, err
+}
+
+// quarantine marks key as quarantined and persists the mark. The caller holds mu shared.
+func (db *DB) quarantine(key Key) error {
+	db.quarantineMu.Lock()
+	defer db.quarantineMu.Unlock()
+	if db.quarantined[key] {
+		return nil
+	}
+	db.quarantined[key] = true
+	if db.log == nil {
+		return nil
+	}
+	return db.writeManifest()
+}
+
+// Quarantined reports whether key has been quarantined by the QuarantineOutOfOrder policy.
+func (db *DB) Quarantined(key Key) bool {
+	db.quarantineMu.Lock()
+	defer db.quarantineMu.Unlock()
+	return db.quarantined[key]
+}
+
+// Release lifts the quarantine of key. Its versions are read as they are stored again.
+func (db *DB) Release(key Key) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.quarantineMu.Lock()
+	defer db.quarantineMu.Unlock()
+	if !db.quarantined[key] {
+		return nil
+	}
+	delete(db.quarantined, key)
+	if db.log == nil {
+		return nil
+	}
+	return db.writeManifest()
+}
+
+// lookupVersions is the slow path of lookupOrdered, taken when the version found or its successor has been deleted
+// or is out of order: it merges the versions of key held by every component, drops the deleted ones and, under the
+// ResortOutOfOrder policy, re-sorts them by sequence number before looking up the version valid at time.
+func (db *DB) lookupVersions(key Key, time ValidTime) (message, next *Message, spans seqSpans, err error) {
+	versions, deleted, err := db.versions(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if db.opts.OutOfOrderPolicy == ResortOutOfOrder {
+		versions = resortVersions(versions)
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].creationTime > time })
+	if i > 0 {
+		message = &versions[i-1]
+	}
+	if i < len(versions) {
+		next = &versions[i]
+	}
+	return message, next, db.spans(key).union(deleted), nil
+}
+
+// resortVersions orders versions by sequence number and raises the creation time of every version
+// to the one of its predecessor, so that both orders agree.
+func resortVersions(versions []Message) []Message {
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].sequenceNumber < versions[j].sequenceNumber })
+	for i := 1; i < len(versions); i++ {
+		if versions[i].creationTime < versions[i-1].creationTime {
+			versions[i].creationTime = versions[i-1].creationTime
+		}
+	}
+	return versions
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"testing"
+)
+
+// checkGet checks the version and the status Get returns at time.
+func checkGet(t *testing.T, db *DB, key Key, time ValidTime, seq SequenceNumber, status Status, next SequenceNumber) {
+	t.Helper()
+	message, s, n, _ := db.Get(key, time)
+	if message.SequenceNumber() != seq || s != status || n != next {
+		t.Fatalf("Get(%d, %d) = %s %s %d, want version %d %s %d", key, time, message, s, n, seq, status, next)
+	}
+}
+
+func TestDB_RejectOutOfOrder(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(1, 5, 10, "value 5"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		seq  SequenceNumber
+		time ValidTime
+	}{{3, 20}, {7, 5}} {
+		if err := db.Put(1, c.seq, c.time, "bad"); err == nil {
+			t.Fatalf("Put(1, %d, %d) succeeded", c.seq, c.time)
+		} else if _, ok := err.(SequenceOutOfOrder); !ok {
+			t.Fatalf("Put(1, %d, %d) = %v, want SequenceOutOfOrder", c.seq, c.time, err)
+		}
+	}
+	checkGet(t, db, 1, 15, 5, ODV, 0)
+	checkGet(t, db, 1, 5, 0, NOTFOUND, 5)
+}
+
+func TestDB_ResortOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{OutOfOrderPolicy: ResortOutOfOrder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 3, 30, "value 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 5, 50, "value 5"); err != nil {
+		t.Fatal(err)
+	}
+	// version 2 is moved back to the creation time of version 3
+	if err := db.Put(1, 2, 40, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, 1, 15, 1, OK, 2)
+	checkGet(t, db, 1, 30, 3, HOLE, 5)
+	// a retry of the moved version is a duplicate
+	if err := db.Put(1, 2, 40, "value 2"); err != nil || db.Stats().Duplicates != 1 {
+		t.Fatalf("retry of the moved version = %v, %d duplicates", err, db.Stats().Duplicates)
+	}
+	// version 4 is moved forward to the creation time of version 3
+	if err := db.Put(1, 4, 5, "value 4"); err != nil {
+		t.Fatal(err)
+	}
+	checkGet(t, db, 1, 30, 4, OK, 5)
+	checkGet(t, db, 1, 50, 5, ODV, 0)
+
+	// the moved versions keep their new creation times only, even under another policy
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	records, err := db.records(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if seq := record.SequenceNumber(); (seq == 2 || seq == 4) && record.CreationTime() != 30 {
+			t.Fatalf("version %d stored at %d, want 30", seq, record.CreationTime())
+		}
+	}
+}
+
+func TestDB_QuarantineOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{OutOfOrderPolicy: QuarantineOutOfOrder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 2, 30, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(2, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 3, 20, "value 3"); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Quarantined(1) || db.Quarantined(2) {
+		t.Fatal("wrong keys quarantined")
+	}
+	if _, status, _, err := db.Get(1, 25); status != QUARANTINED {
+		t.Fatalf("Get(1, 25) = %s %v, want QUARANTINED", status, err)
+	} else if _, ok := err.(KeyQuarantined); !ok {
+		t.Fatalf("Get(1, 25) = %v, want KeyQuarantined", err)
+	}
+	checkGet(t, db, 2, 10, 1, ODV, 0)
+
+	// the quarantine survives a restart
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dir, &Options{OutOfOrderPolicy: QuarantineOutOfOrder}); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Quarantined(1) {
+		t.Fatal("quarantine lost after restart")
+	}
+	if err := db.Release(1); err != nil {
+		t.Fatal(err)
+	}
+	// the versions are read as stored
+	if _, status, _, err := db.Get(1, 25); status != ERROR || err == nil {
+		t.Fatalf("Get(1, 25) = %s %v, want ERROR", status, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reading with the Resort policy re-sorts the stored versions
+	if db, err = Open(dir, &Options{OutOfOrderPolicy: ResortOutOfOrder}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Quarantined(1) {
+		t.Fatal("release lost after restart")
+	}
+	checkGet(t, db, 1, 25, 1, OK, 2)
+	checkGet(t, db, 1, 35, 3, ODV, 0)
+}
+
+func TestQueryPool_OutOfOrderPolicy(t *testing.T) {
+	for _, c := range []struct {
+		policy    OutOfOrderPolicy
+		completed bool
+		status    Status
+	}{
+		{RejectOutOfOrder, false, ODV},
+		{ResortOutOfOrder, false, ODV},
+		{QuarantineOutOfOrder, true, QUARANTINED},
+	} {
+		pool := NewQueryPool()
+		pool.SetOutOfOrderPolicy(c.policy)
+		query := NewQuery(100, 25, 1, 1000, 1.0)
+		query.NewResult(1, NewMessage(20, 2, "value 2"), ODV, 0, 0.5)
+		pool.Add(query)
+		query.SetPool(pool)
+
+		// created after the current version, but preceding it in sequence
+		completed, _ := pool.Update(110, 1, NewMessage(30, 1, "value 1"))
+		if (len(completed) == 1) != c.completed || query.Result(1).Status() != c.status {
+			t.Fatalf("policy %d: completed %d queries with status %s, want %t %s", c.policy, len(completed), query.Result(1).Status(), c.completed, c.status)
+		}
+	}
+}
+
+func TestDB_DuplicateVersions(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for j := 1; j <= 20; j++ {
+		if err := db.Put(1, SequenceNumber(j), ValidTime(j*10), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// retries of flushed and unflushed versions
+	for _, j := range []int{1, 10, 20} {
+		if err := db.PutAt(1, SequenceNumber(j), ValidTime(j*10), 1000, "value"); err != nil {
+			t.Fatalf("retry of version %d = %v", j, err)
+		}
+	}
+	// the retries are not written again, so the original arrival times are kept
+	if message, _, _, _ := db.GetAsOf(1, 100, 0); message.SequenceNumber() != 10 {
+		t.Fatalf("GetAsOf(1, 100, 0) = %s, want version 10", message)
+	}
+	for _, c := range []struct {
+		seq   SequenceNumber
+		time  ValidTime
+		value string
+	}{{5, 50, "other value"}, {5, 55, "value"}, {20, 300, "value"}} {
+		if err := db.Put(1, c.seq, c.time, c.value); err == nil {
+			t.Fatalf("conflicting version %d at %d succeeded", c.seq, c.time)
+		} else if _, ok := err.(ConflictingVersion); !ok {
+			t.Fatalf("conflicting version %d at %d = %v, want ConflictingVersion", c.seq, c.time, err)
+		}
+	}
+	if stats := db.Stats(); stats.Duplicates != 3 || stats.Conflicts != 3 {
+		t.Fatalf("Stats() = %+v, want 3 duplicates and 3 conflicts", stats)
+	}
+	checkGet(t, db, 1, 55, 5, OK, 6)
+}
+
+func TestDB_ConflictingVersionAway(t *testing.T) {
+	for _, policy := range []OutOfOrderPolicy{RejectOutOfOrder, ResortOutOfOrder, QuarantineOutOfOrder} {
+		db, err := Open(t.TempDir(), &Options{OutOfOrderPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j <= 5; j++ {
+			if err := db.Put(1, SequenceNumber(j), ValidTime(j*10), "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the stored version 2 is far from the creation time of the new one
+		if err := db.Put(1, 2, 45, "other"); err == nil {
+			t.Fatalf("policy %d: conflicting version succeeded", policy)
+		} else if _, ok := err.(ConflictingVersion); !ok {
+			t.Fatalf("policy %d: conflicting version = %v, want ConflictingVersion", policy, err)
+		}
+		if versions, _, _ := db.versions(1); len(versions) != 5 || db.Quarantined(1) {
+			t.Fatalf("policy %d: %d versions stored, quarantined %t", policy, len(versions), db.Quarantined(1))
+		}
+		checkGet(t, db, 1, 45, 4, OK, 5)
+		db.Close()
+	}
+}
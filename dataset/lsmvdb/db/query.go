@@ -0,0 +1,123 @@
+package db
+
+import "sync/atomic"
+
+// QueryOptions controls a query issued by Query.
+type QueryOptions struct {
+	// ArrivalTime is the time the query is issued. Its deadline runs from it.
+	// The default is the clock of the database (see DB.Advance).
+	ArrivalTime ValidTime
+	// Deadline is the time the query waits for its final results after its arrival.
+	// The default is Options.QueryDeadline.
+	Deadline ValidTime
+	// Correctness is the probability of temporal correctness at which the query completes before its results
+	// are final. The default is Options.QueryCorrectness.
+	Correctness float64
+}
+
+// Query reads the versions of keys valid at requestTime, and returns the query holding them as the handle to
+// its results. If the results are not final, i.e., a key is not OK and the probability of temporal correctness
+// of the results is below the correctness threshold of the query, the query is parked in the pool of the database:
+// every write that follows updates its results, until they become final or the deadline of the query passes. Done
+// is closed when the query completes. If Options.RetainQueries is set, the completed queries are kept until
+// ForgetQueries drops them, and Delete and Amend flag those that returned the version they correct as Retracted.
+// A nil opts selects the default query options.
+//
+// Query fails with the error of Get if a key cannot be read.
+func (db *DB) Query(keys []Key, requestTime ValidTime, opts *QueryOptions) (*Query, error) {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+	arrivalTime := opts.ArrivalTime
+	if arrivalTime == 0 {
+		arrivalTime = db.now()
+	}
+	deadline, ck := opts.Deadline, opts.Correctness
+	if deadline == 0 {
+		deadline = db.opts.QueryDeadline
+	}
+	if ck <= 0 {
+		ck = db.opts.QueryCorrectness
+	}
+	unique := make([]Key, 0, len(keys))
+	seen := make(map[Key]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	// writes skip the pool while no query is active, so count the query before reading any version
+	atomic.AddInt64(&db.active, 1)
+	parked := false
+	defer func() {
+		if !parked {
+			atomic.AddInt64(&db.active, -1)
+		}
+	}()
+	// a write completing while the versions are read updates the query once it is parked
+	db.queryMu.Lock()
+	defer db.queryMu.Unlock()
+	query := NewQuery(arrivalTime, requestTime, len(unique), deadline, ck)
+	parked, err := db.queries.Execute(query, unique, db.Get)
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// updateQueries updates the pending queries with a version of key that has just been written.
+// The creation time and the arrival time of the version advance the clock of the database, so that the versions
+// written by Put, which arrive at 0, drive the deadlines as well.
+func (db *DB) updateQueries(key Key, message Message) {
+	clock := message.creationTime
+	if message.arrivalTime > clock {
+		clock = message.arrivalTime
+	}
+	clock = db.tick(clock)
+	if atomic.LoadInt64(&db.active) == 0 {
+		return
+	}
+	db.queryMu.Lock()
+	defer db.queryMu.Unlock()
+	expired := db.queries.Advance(clock)
+	completed, _ := db.queries.Update(clock, key, &message)
+	atomic.AddInt64(&db.active, -int64(len(expired)+len(completed)))
+}
+
+// Advance moves the clock of the database to clock, unless it is past it already. The deadlines of the pending
+// queries issued by Query are measured against the clock: the queries whose deadline has passed complete with the
+// reason Timeout at their deadline, whether or not a version of their keys has arrived. Writes advance the clock to
+// the creation time or the arrival time of their version, whichever is later.
+func (db *DB) Advance(clock ValidTime) {
+	clock = db.tick(clock)
+	if atomic.LoadInt64(&db.active) == 0 {
+		return
+	}
+	db.queryMu.Lock()
+	defer db.queryMu.Unlock()
+	expired := db.queries.Advance(clock)
+	atomic.AddInt64(&db.active, -int64(len(expired)))
+}
+
+// ForgetQueries drops the completed queries issued by Query that arrived before arrivalTime (see Options.RetainQueries).
+// Delete and Amend no longer flag them.
+func (db *DB) ForgetQueries(arrivalTime ValidTime) {
+	db.queryMu.Lock()
+	defer db.queryMu.Unlock()
+	db.queries.Forget(arrivalTime)
+}
+
+// tick raises the clock of the database to t, and returns the clock.
+func (db *DB) tick(t ValidTime) ValidTime {
+	for {
+		clock := atomic.LoadUint64((*uint64)(&db.clock))
+		if uint64(t) <= clock {
+			return ValidTime(clock)
+		}
+		if atomic.CompareAndSwapUint64((*uint64)(&db.clock), clock, uint64(t)) {
+			return t
+		}
+	}
+}
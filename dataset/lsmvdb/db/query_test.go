@@ -0,0 +1,339 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// isDone reports whether the query has completed.
+func isDone(query *Query) bool {
+	select {
+	case <-query.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDB_Query(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{QueryDeadline: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []Key{1, 2} {
+		if err := db.Put(key, 1, 10, "value 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a query arrives at the clock of the database by default
+	db.Advance(100)
+	query, err := db.Query([]Key{1, 2}, 15, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.ArrivalTime() != 100 || isDone(query) {
+		t.Fatalf("query with ODV results arrived at %d, done %t", query.ArrivalTime(), isDone(query))
+	}
+	// pending queries may share their arrival time
+	twin, err := db.Query([]Key{2}, 15, &QueryOptions{ArrivalTime: 100})
+	if err != nil || isDone(twin) {
+		t.Fatalf("Query with the arrival time of a pending one = %v, done %t", err, isDone(twin))
+	}
+
+	// the writes drive the pending queries
+	if err := db.Put(1, 2, 20, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	if isDone(query) || query.Result(1).status != OK {
+		t.Fatalf("after the successor of key 1: done %t, status %s", isDone(query), query.Result(1).status)
+	}
+	if err := db.Put(2, 2, 20, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	if !isDone(query) || query.Result(2).status != OK || !isDone(twin) {
+		t.Fatalf("after the successor of key 2: done %t and %t, status %s", isDone(query), isDone(twin), query.Result(2).status)
+	}
+
+	// a query answered by its first execution is done at once
+	answered, err := db.Query([]Key{1, 2}, 15, nil)
+	if err != nil || !isDone(answered) {
+		t.Fatalf("Query of confirmed versions = %v, done %t", err, isDone(answered))
+	}
+
+	// a pending query times out once the clock passes its deadline
+	late, err := db.Query([]Key{1}, 25, &QueryOptions{ArrivalTime: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Advance(250)
+	if isDone(late) {
+		t.Fatal("query timed out at its deadline")
+	}
+	db.Advance(260)
+	if !isDone(late) || late.Result(1).status != ODV {
+		t.Fatalf("after the deadline: done %t, status %s", isDone(late), late.Result(1).status)
+	}
+}
+
+func TestDB_QueryRetraction(t *testing.T) {
+	db, err := Open("", &Options{RetainQueries: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []Key{1, 2} {
+		for seq := 1; seq <= 2; seq++ {
+			if err := db.Put(key, SequenceNumber(seq), ValidTime(seq*10), "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// both queries arrive at 20: the first one is answered at once, the second one waits for version 3
+	answered, err := db.Query([]Key{1, 2}, 15, nil)
+	if err != nil || !isDone(answered) {
+		t.Fatalf("Query of confirmed versions = %v, done %t", err, isDone(answered))
+	}
+	pending, err := db.Query([]Key{1}, 25, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(1, 3, 30, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if !isDone(pending) {
+		t.Fatal("query not completed by the successor of its result")
+	}
+
+	// correcting a version flags the completed queries that returned it, whether they waited or not
+	if err := db.Amend(1, 2, "amended"); err != nil {
+		t.Fatal(err)
+	}
+	if !pending.Retracted() || answered.Retracted() {
+		t.Fatalf("after Amend(1, 2): retracted %t and %t, want true and false", pending.Retracted(), answered.Retracted())
+	}
+	if err := db.Delete(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !answered.Retracted() {
+		t.Fatal("Delete(2, 1) did not flag the query answered by its first execution")
+	}
+
+	// forgotten queries are no longer flagged
+	fresh, err := db.Query([]Key{1}, 15, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.ForgetQueries(fresh.ArrivalTime() + 1)
+	if err := db.Delete(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Retracted() {
+		t.Fatal("Delete(1, 1) flagged a forgotten query")
+	}
+
+	// by default, the completed queries are not retained
+	plain, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := plain.Put(1, 1, 10, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Put(1, 2, 20, "value"); err != nil {
+		t.Fatal(err)
+	}
+	query, err := plain.Query([]Key{1}, 15, nil)
+	if err != nil || !isDone(query) {
+		t.Fatalf("Query of a confirmed version = %v, done %t", err, isDone(query))
+	}
+	if len(plain.queries.completed) != 0 {
+		t.Fatalf("the pool retains %d keys of completed queries", len(plain.queries.completed))
+	}
+	if err := plain.Delete(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if query.Retracted() {
+		t.Fatal("Delete(1, 1) flagged a query that was not retained")
+	}
+}
+
+func TestDB_QuerySubscribe(t *testing.T) {
+	db, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(1, 2, 10, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := db.Query([]Key{1}, 25, &QueryOptions{ArrivalTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan SequenceNumber, 10)
+	finals := 0
+	query.Subscribe(func(query *Query, final bool) {
+		if final {
+			finals++
+		}
+		events <- query.Result(1).Message().SequenceNumber()
+	})
+	// a version valid at the request time refines the answer, and its successor confirms it
+	if err := db.PutAt(1, 3, 20, 110, "value 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAt(1, 4, 30, 120, "value 4"); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	var got []SequenceNumber
+	for seq := range events {
+		got = append(got, seq)
+	}
+	if len(got) != 2 || got[0] != 3 || got[1] != 3 || finals != 1 {
+		t.Fatalf("events %v with %d final, want [3 3] with 1 final", got, finals)
+	}
+
+	// subscribing to a completed query reports its completion at once
+	late := false
+	query.Subscribe(func(_ *Query, final bool) { late = final })
+	if !late {
+		t.Fatal("subscriber of a completed query not called")
+	}
+}
+
+func TestDB_QueryReason(t *testing.T) {
+	db, err := Open("", &Options{QueryDeadline: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(2, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmed, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 100})
+	expired, _ := db.Query([]Key{2}, 15, &QueryOptions{ArrivalTime: 100})
+	if err := db.PutAt(1, 2, 20, 120, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAt(2, 3, 30, 170, "value 3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		query       *Query
+		key         Key
+		reason      Reason
+		completedAt ValidTime
+		status      Status
+	}{{confirmed, 1, NonODV, 120, OK}, {expired, 2, Timeout, 150, ODV}} {
+		if c.query.Reason() != c.reason || c.query.CompletedAt() != c.completedAt || c.query.Statuses()[c.key] != c.status {
+			t.Fatalf("query of key %d completed at %d with %s and status %s, want %d %s %s", c.key,
+				c.query.CompletedAt(), c.query.Reason(), c.query.Statuses()[c.key], c.completedAt, c.reason, c.status)
+		}
+	}
+
+	if query, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 200}); query.Reason() != NonODV || query.CompletedAt() != 200 {
+		t.Fatalf("query answered at once completed at %d with %s", query.CompletedAt(), query.Reason())
+	}
+	// the reasons print by name on their own
+	if got := fmt.Sprint(NonODV, Timeout, MaybeCorrect); got != "NonODV Timeout MaybeCorrect" {
+		t.Fatalf("reasons print as %q", got)
+	}
+}
+
+func TestDB_QueryConcurrentReads(t *testing.T) {
+	db, err := Open("", &Options{RetainQueries: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	query, err := db.Query([]Key{1}, 405, &QueryOptions{ArrivalTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the accessors may be polled while the writes drive the pool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !isDone(query) || !query.Retracted() {
+			_, _, _ = query.Statuses(), query.Reason(), query.CompletedAt()
+		}
+	}()
+	for seq := 2; seq <= 50; seq++ {
+		if err := db.PutAt(1, SequenceNumber(seq), ValidTime(seq*10), ValidTime(100+seq), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Amend(1, 40, "amended"); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if query.Reason() != NonODV || query.Statuses()[1] != OK {
+		t.Fatalf("query completed with %s and status %s", query.Reason(), query.Statuses()[1])
+	}
+}
+
+func TestDB_Advance(t *testing.T) {
+	db, err := Open("", &Options{QueryDeadline: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	query, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 100})
+	db.Advance(150)
+	if isDone(query) {
+		t.Fatal("query expired before its deadline")
+	}
+	// no version of key 1 arrives, but the query expires anyway
+	db.Advance(151)
+	if !isDone(query) || query.Reason() != Timeout || query.CompletedAt() != 150 {
+		t.Fatalf("query completed at %d with %s, want a timeout at 150", query.CompletedAt(), query.Reason())
+	}
+}
+
+func TestDB_QueryOptions(t *testing.T) {
+	db, err := Open("", &Options{QueryDeadline: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetSensors(map[int][]int{1: {10, 1}})
+	if err := db.Put(1, 1, 10, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the version is almost surely correct, which satisfies a lower threshold only
+	audit, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 100})
+	interactive, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 101, Correctness: 0.9})
+	if isDone(audit) || interactive.Reason() != MaybeCorrect {
+		t.Fatalf("done %t and %s, want the audit query pending and the other one correct", isDone(audit), interactive.Reason())
+	}
+
+	// each query waits until its own deadline
+	short, _ := db.Query([]Key{1}, 15, &QueryOptions{ArrivalTime: 110, Deadline: 10})
+	db.Advance(121)
+	if !isDone(short) || short.CompletedAt() != 120 || isDone(audit) {
+		t.Fatalf("short query done %t at %d, audit query done %t", isDone(short), short.CompletedAt(), isDone(audit))
+	}
+	db.Advance(151)
+	if audit.Reason() != Timeout || audit.CompletedAt() != 150 {
+		t.Fatalf("audit query completed at %d with %s, want a timeout at 150", audit.CompletedAt(), audit.Reason())
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+// SetRetention sets the valid-time span of history kept for key, overriding opts.Retention.
+// A retention of 0 restores opts.Retention. The retention is persisted in the manifest.
+//
+// The retention horizon of a key is the latest creation time written to the database minus its retention, so that
+// it advances in an in-memory database as well. Reads below the horizon report EXPIRED. Flushes and compactions
+// drop the versions whose validity ends at or before the horizon as of the archive time of the flushed memtable,
+// keeping the version valid at the horizon. Extending the retention does not restore the versions dropped before.
+//
+// Delete fails with VersionExpired for a version created at or before the horizon: the version preceding it, which
+// would become valid again, may have been dropped.
+func (db *DB) SetRetention(key Key, retention ValidTime) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if retention == 0 {
+		delete(db.retention, key)
+	} else {
+		db.retention[key] = retention
+	}
+	if db.log == nil {
+		return nil
+	}
+	return db.writeManifest()
+}
+
+// Horizon returns the current retention horizon of key. Reads of key below it report EXPIRED.
+func (db *DB) Horizon(key Key) ValidTime {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.horizon(key, db.mem.latest())
+}
+
+// horizon returns the retention horizon of key as of now. The caller holds mu.
+func (db *DB) horizon(key Key, now ValidTime) ValidTime {
+	retention, ok := db.retention[key]
+	if !ok {
+		retention = db.opts.Retention
+	}
+	if retention == 0 || now <= retention {
+		return 0
+	}
+	return now - retention
+}
+
+// expired reports whether a read of key at time is below its retention horizon. The caller holds mu.
+func (db *DB) expired(key Key, time ValidTime) bool {
+	return time < db.horizon(key, db.mem.latest())
+}
+
+// expire drops the versions in creation-time order whose validity ends at or before horizon, i.e., those succeeded
+// by a version created at or before horizon, and returns the kept versions and the sequence numbers of the dropped
+// ones. Tombstones are kept, and do not end the validity of the version they follow.
+func expire(versions []Message, horizon ValidTime) (kept []Message, dropped seqSpans) {
+	if horizon == 0 {
+		return versions, nil
+	}
+	drop := make([]bool, len(versions))
+	end := OpenEnd
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].kind == tombstoneKind {
+			continue
+		}
+		drop[i] = end <= horizon
+		end = versions[i].creationTime
+	}
+	kept = make([]Message, 0, len(versions))
+	for i, version := range versions {
+		if drop[i] {
+			dropped = dropped.add(version.sequenceNumber)
+			continue
+		}
+		kept = append(kept, version)
+	}
+	return kept, dropped
+}
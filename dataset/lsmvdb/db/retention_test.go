@@ -0,0 +1,117 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_Retention(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 256, LevelRuns: 2, Retention: 200}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetRetention(2, 50); err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j <= 100; j++ {
+		for k := 1; k <= 2; k++ {
+			if err := db.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	horizon := db.Horizon(1)
+	if horizon == 0 || db.Horizon(2) != horizon+150 {
+		t.Fatalf("horizons %d and %d, want a difference of 150", horizon, db.Horizon(2))
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for k := Key(1); k <= 2; k++ {
+			h := db.Horizon(k)
+			if _, status, _, _ := db.Get(k, h-1); status != EXPIRED {
+				t.Fatalf("Get(%d, %d) = %s, want EXPIRED", k, h-1, status)
+			}
+			// the version valid at the horizon is kept
+			checkGet(t, db, k, h, SequenceNumber(h/10), OK, SequenceNumber(h/10)+1)
+			versions, _, err := db.versions(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) == 100 {
+				t.Fatalf("key %d: no version has been dropped", k)
+			}
+			it := db.NewVersionIterator(k, 0, OpenEnd)
+			if !it.Next() || it.Message().SequenceNumber() != SequenceNumber(h/10) {
+				t.Fatalf("key %d: iterator starts at %s, want version %d", k, it.Message(), h/10)
+			}
+		}
+	}
+	check(db)
+
+	// the retention of key 2 survives a restart
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Horizon(2) != db.Horizon(1)+150 {
+		t.Fatal("retention of key 2 lost after restart")
+	}
+	check(db)
+
+	// deleting the version valid at the horizon would leave no version valid there
+	h := db.Horizon(1)
+	if err := db.Delete(1, SequenceNumber(h/10)); err == nil {
+		t.Fatal("Delete of the version valid at the horizon succeeded")
+	} else if _, ok := err.(VersionExpired); !ok {
+		t.Fatalf("Delete of the version valid at the horizon = %v, want VersionExpired", err)
+	}
+	checkGet(t, db, 1, h, SequenceNumber(h/10), OK, SequenceNumber(h/10)+1)
+	if err := db.Delete(1, 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_RetentionInMemory(t *testing.T) {
+	db, err := Open("", &Options{Retention: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for j := 1; j <= 100; j++ {
+		if err := db.Put(1, SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the horizon follows the writes, although no memtable is flushed
+	if h := db.Horizon(1); h != 950 {
+		t.Fatalf("Horizon(1) = %d, want 950", h)
+	}
+	if _, status, _, _ := db.Get(1, 949); status != EXPIRED {
+		t.Fatalf("Get(1, 949) = %s, want EXPIRED", status)
+	}
+	checkGet(t, db, 1, 950, 95, OK, 96)
+	if _, ok := db.Delete(1, 95).(VersionExpired); !ok {
+		t.Fatal("Delete of the version valid at the horizon did not fail with VersionExpired")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	versions := []Message{
+		{creationTime: 10, sequenceNumber: 1},
+		{creationTime: 20, sequenceNumber: 2},
+		{creationTime: 30, sequenceNumber: 3, kind: tombstoneKind},
+		{creationTime: 40, sequenceNumber: 4},
+		{creationTime: 50, sequenceNumber: 5},
+	}
+	kept, dropped := expire(versions, 39)
+	// version 2 stays valid until version 4, since version 3 has been deleted
+	if len(kept) != 4 || kept[0].sequenceNumber != 2 || !dropped.contains(1) || dropped.contains(2) {
+		t.Fatalf("expire kept %v and dropped %v", kept, dropped)
+	}
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// Delete retracts the version of key with the given sequence number. Get, GetAsOf and the iterators no longer
+// return the version: the preceding version stays valid until the creation time of the succeeding one.
+// The sequence number of a deleted version still counts as written, so a version followed by a deleted one
+// is reported OK. Delete fails with VersionNotFound if the key holds no such version.
+//
+// Delete fails with VersionExpired if the version was created at or before the retention horizon of the key.
+//
+// The version is shadowed by a tombstone carrying its creation time and sequence number. Compactions drop the
+// tombstone together with the version once it reaches the bottom level.
+// The completed queries issued by Query that returned the version are flagged as retracted if they are retained
+// (see Options.RetainQueries).
+// The deletion arrives at the clock of the database; see DeleteAt.
+func (db *DB) Delete(key Key, sequenceNumber SequenceNumber) error {
+	return db.DeleteAt(key, sequenceNumber, db.now())
+}
+
+// DeleteAt is like Delete, but records the arrival time of the deletion. GetAsOf returns the version as of the
+// arrival times before it.
+func (db *DB) DeleteAt(key Key, sequenceNumber SequenceNumber, arrivalTime ValidTime) error {
+	return db.correct(key, sequenceNumber, arrivalTime, tombstoneKind, Value{})
+}
+
+// Amend replaces the value of the version of key with the given sequence number, e.g., with a correction sent by
+// a sensor. The version keeps its creation time and its arrival time, and reports Amended from then on.
+// Like Delete, it flags the completed queries that returned the version. Amend fails with VersionNotFound if the key
+// holds no such version. The amendment arrives at the clock of the database; see AmendAt.
+func (db *DB) Amend(key Key, sequenceNumber SequenceNumber, value string) error {
+	return db.AmendAt(key, sequenceNumber, db.now(), value)
+}
+
+// AmendAt is like Amend, but records the arrival time of the amendment. GetAsOf returns the preceding value
+// as of the arrival times before it.
+func (db *DB) AmendAt(key Key, sequenceNumber SequenceNumber, arrivalTime ValidTime, value string) error {
+	return db.correct(key, sequenceNumber, arrivalTime, amendmentKind, StringValue(value))
+}
+
+// AmendValue is like Amend, but replaces the value with a typed one.
+func (db *DB) AmendValue(key Key, sequenceNumber SequenceNumber, value Value) error {
+	return db.correct(key, sequenceNumber, db.now(), amendmentKind, value)
+}
+
+// now returns the clock of the database.
+func (db *DB) now() ValidTime {
+	return ValidTime(atomic.LoadUint64((*uint64)(&db.clock)))
+}
+
+// correct writes a record of the given kind for the version of key with the given sequence number.
+func (db *DB) correct(key Key, sequenceNumber SequenceNumber, arrivalTime ValidTime, kind messageKind, value Value) error {
+	lock := db.keyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	db.mu.RLock()
+	versions, _, err := db.versions(key)
+	horizon := db.horizon(key, db.mem.latest())
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.sequenceNumber == sequenceNumber {
+			if kind == tombstoneKind && horizon > 0 && version.creationTime <= horizon {
+				return VersionExpired{key, sequenceNumber}
+			}
+			// the record takes the stored creation time, so that it shadows the version
+			record := version
+			record.kind, record.value, record.valueType, record.correctionTime = kind, value.data, value.typ, arrivalTime
+			record.superseded = string(appendRecord([]byte(version.superseded), version))
+			if err = db.write(key, record); err != nil {
+				return err
+			}
+			db.queryMu.Lock()
+			db.queries.Retract(key, sequenceNumber)
+			db.queryMu.Unlock()
+			return nil
+		}
+	}
+	return VersionNotFound{key, sequenceNumber}
+}
+
+// asOf returns the record m as it was at arrivalTime: m itself if it arrived by then, or else the newest record it
+// superseded that did. It returns false if the version itself had not arrived yet.
+func (m Message) asOf(arrivalTime ValidTime) (Message, bool) {
+	if m.arrivalTime > arrivalTime {
+		return Message{}, false
+	}
+	if m.correctionTime <= arrivalTime {
+		return m, true
+	}
+	// the version as written by Put comes first, with correction time 0
+	record := m
+	d := decoder{buf: []byte(m.superseded)}
+	for len(d.buf) > 0 && d.err == nil {
+		s := d.record(m)
+		if s.correctionTime > arrivalTime {
+			break
+		}
+		record = s
+	}
+	return record, true
+}
+
+// appendRecord appends the record m to the records superseded by a correction of its version:
+//
+//	correctionTime uvarint | kind uvarint | valueType uvarint | len uvarint | value
+//
+// The superseded records are stored oldest first, and share the creation time, the sequence number and
+// the arrival time of the correction. The records superseded by m are not appended.
+func appendRecord(buf []byte, m Message) []byte {
+	buf = binary.AppendUvarint(buf, uint64(m.correctionTime))
+	buf = binary.AppendUvarint(buf, uint64(m.kind))
+	buf = binary.AppendUvarint(buf, uint64(m.valueType))
+	buf = binary.AppendUvarint(buf, uint64(len(m.value)))
+	return append(buf, m.value...)
+}
+
+// record reads a record written by appendRecord for a correction of the version of m.
+func (d *decoder) record(m Message) Message {
+	s := Message{creationTime: m.creationTime, sequenceNumber: m.sequenceNumber, arrivalTime: m.arrivalTime}
+	s.correctionTime = ValidTime(d.uvarint())
+	s.kind = messageKind(d.uvarint())
+	s.valueType = ValueType(d.uvarint())
+	s.value = string(d.bytes(int(d.uvarint())))
+	return s
+}
+
+// appendCorrection appends the correction time and the superseded records of a tombstone or an amendment:
+//
+//	correctionTime uvarint | len uvarint | superseded [len]byte
+//
+// Nothing is appended for a version as written by Put.
+func appendCorrection(buf []byte, m Message) []byte {
+	if m.kind == valueKind {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(m.correctionTime))
+	buf = binary.AppendUvarint(buf, uint64(len(m.superseded)))
+	return append(buf, m.superseded...)
+}
+
+// correction reads what appendCorrection wrote for m.
+func (d *decoder) correction(m *Message) {
+	if m.kind == valueKind {
+		return
+	}
+	m.correctionTime = ValidTime(d.uvarint())
+	m.superseded = string(d.bytes(int(d.uvarint())))
+}
+
+// deleted reports whether the record m is a tombstone. A nil record is not.
+func (m *Message) deleted() bool {
+	return m != nil && m.kind == tombstoneKind
+}
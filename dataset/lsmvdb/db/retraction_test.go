@@ -0,0 +1,194 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_DeleteAndAmend(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 512, LevelRuns: 2}
+	memDB, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskDB, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j <= 60; j++ {
+		for k := 1; k <= 3; k++ {
+			for _, db := range []*DB{memDB, diskDB} {
+				if err := db.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		// retract and correct flushed and unflushed versions
+		if j%10 == 0 {
+			for _, db := range []*DB{memDB, diskDB} {
+				if err := db.Delete(1, SequenceNumber(j-5)); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Amend(2, SequenceNumber(j-1), "amended"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := diskDB.Delete(1, 100); err == nil {
+		t.Fatal("Delete of a missing version succeeded")
+	} else if _, ok := err.(VersionNotFound); !ok {
+		t.Fatalf("Delete(1, 100) = %v, want VersionNotFound", err)
+	}
+	// a retried write does not restore a deleted version
+	if err := diskDB.Put(1, 5, 50, "value 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		// the deleted version is skipped, but its sequence number counts as written
+		checkGet(t, db, 1, 45, 4, OK, 5)
+		checkGet(t, db, 1, 55, 4, OK, 5)
+		checkGet(t, db, 1, 60, 6, OK, 7)
+		if result, _, _, _ := db.GetInterval(1, 45); result.End() != 60 {
+			t.Fatalf("GetInterval(1, 45) ends at %d, want 60", result.End())
+		}
+		message, _, _, _ := db.Get(2, 95)
+		if message.Value() != "amended" || !message.Amended() {
+			t.Fatalf("Get(2, 95) = %s, want the amended version", message)
+		}
+		it := db.NewVersionIterator(1, 0, OpenEnd)
+		for it.Next() {
+			if it.Message().SequenceNumber()%10 == 5 {
+				t.Fatalf("iterator yields deleted version %s", it.Message())
+			}
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		compareGets(t, memDB, db)
+	}
+	check(diskDB)
+
+	if err := diskDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diskDB, err = Open(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	check(diskDB)
+
+	// compact everything into a single bottom level, which drops the tombstones
+	for j := 61; j <= 200; j++ {
+		if err := diskDB.Put(3, SequenceNumber(j), ValidTime(j*10), "filler"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bottom := diskDB.levels[len(diskDB.levels)-1]
+	for _, t0 := range bottom {
+		versions, err := t0.versions(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, version := range versions {
+			if version.kind == tombstoneKind {
+				t.Fatalf("bottom level holds tombstone %s", version)
+			}
+		}
+	}
+	checkGet(t, diskDB, 1, 45, 4, OK, 5)
+	checkGet(t, diskDB, 1, 55, 4, OK, 5)
+}
+
+func TestQueryPool_Retract(t *testing.T) {
+	pool := NewQueryPool()
+	pool.SetSensors(map[int][]int{1: {10, 1}})
+	pool.SetRetain(true)
+	query := NewQuery(100, 25, 1, 1000, 1.0)
+	query.NewResult(1, NewMessage(20, 2, "value 2"), ODV, 0, 0.5)
+	pool.Add(query)
+	query.SetPool(pool)
+	if completed, _ := pool.Update(110, 1, NewMessage(30, 3, "value 3")); len(completed) != 1 {
+		t.Fatalf("completed %d queries, want 1", len(completed))
+	}
+
+	if retracted := pool.Retract(1, 3); len(retracted) != 0 {
+		t.Fatalf("Retract(1, 3) flagged %d queries, want 0", len(retracted))
+	}
+	if retracted := pool.Retract(1, 2); len(retracted) != 1 || !query.Retracted() {
+		t.Fatal("Retract(1, 2) did not flag the query")
+	}
+	pool.Forget(101)
+	if retracted := pool.Retract(1, 2); len(retracted) != 0 {
+		t.Fatal("Retract flagged a forgotten query")
+	}
+}
+
+func TestDB_GetAsOfCorrections(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		if err := db.PutAt(1, SequenceNumber(seq), ValidTime(seq*10), ValidTime(seq*100), fmt.Sprintf("value %d", seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// version 2 is amended twice and then deleted, each correction arriving after the versions
+	if err := db.AmendAt(1, 2, 400, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AmendAt(1, 2, 500, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteAt(1, 2, 600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for _, c := range []struct {
+			arrival ValidTime
+			seq     SequenceNumber
+			value   string
+		}{{300, 2, "value 2"}, {399, 2, "value 2"}, {400, 2, "first"}, {550, 2, "second"}, {600, 1, "value 1"}, {700, 1, "value 1"}} {
+			message, _, _, err := db.GetAsOf(1, 25, c.arrival)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.SequenceNumber() != c.seq || message.Value() != c.value {
+				t.Fatalf("GetAsOf(1, 25, %d) = %s, want version %d with %q", c.arrival, message, c.seq, c.value)
+			}
+			if amended := c.value == "first" || c.value == "second"; message.Amended() != amended {
+				t.Fatalf("GetAsOf(1, 25, %d) reports amended %t", c.arrival, message.Amended())
+			}
+		}
+		// the version itself had not arrived yet
+		if message, _, _, _ := db.GetAsOf(1, 25, 150); message.SequenceNumber() != 1 {
+			t.Fatalf("GetAsOf(1, 25, 150) = %s, want version 1", message)
+		}
+		checkGet(t, db, 1, 25, 1, OK, 2)
+	}
+	check(db)
+
+	// the corrections survive the log replay and a flush into a table
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+	if err := db.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.flush(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
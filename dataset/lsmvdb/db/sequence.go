@@ -0,0 +1,66 @@
+package db
+
+import "sync"
+
+// keyLockStripes is the number of locks serializing the writes to the same key.
+const keyLockStripes = 64
+
+// keyLock returns the lock serializing the writes to key that depend on its stored versions.
+func (db *DB) keyLock(key Key) *sync.Mutex {
+	return &db.keyLocks[key%keyLockStripes]
+}
+
+// Append stores a new version of key created at creationTime and assigns it the next sequence number of the key.
+// See AppendAt.
+func (db *DB) Append(key Key, creationTime ValidTime, value string) (SequenceNumber, error) {
+	return db.AppendAt(key, creationTime, 0, value)
+}
+
+// AppendAt is like Append, but records the arrival time of the version.
+//
+// The assigned sequence number is the highest one written to the key plus one, or 1 for a new key, regardless of
+// whether it was assigned or written with a sensor-assigned one, and of whether its version has since been deleted
+// or dropped by a compaction. The new version must be created after the latest version, otherwise AppendAt fails
+// with CreationTimeNotIncreasing: a version slotted between existing ones could not be given a number that keeps
+// the sequence of the key contiguous. The new version is then admitted like one written by PutAt. Appends and
+// writes with explicit sequence numbers to the same key are serialized, so an append never assigns a number
+// that another write takes.
+func (db *DB) AppendAt(key Key, creationTime, arrivalTime ValidTime, value string) (SequenceNumber, error) {
+	return db.appendValue(key, creationTime, arrivalTime, StringValue(value))
+}
+
+// appendValue implements AppendAt for a typed value.
+func (db *DB) appendValue(key Key, creationTime, arrivalTime ValidTime, value Value) (SequenceNumber, error) {
+	lock := db.keyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	db.mu.RLock()
+	records, err := db.records(key)
+	spans := db.spans(key)
+	db.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	// the records are ordered by creation time
+	if n := len(records); n > 0 && creationTime <= records[n-1].creationTime {
+		return 0, CreationTimeNotIncreasing{key, records[n-1].creationTime, creationTime}
+	}
+	sequenceNumber := SequenceNumber(1)
+	if n := len(spans); n > 0 {
+		sequenceNumber = spans[n-1].last + 1
+	}
+	for _, record := range records {
+		if record.sequenceNumber >= sequenceNumber {
+			sequenceNumber = record.sequenceNumber + 1
+		}
+	}
+	creationTime, _, err = db.admit(key, sequenceNumber, creationTime, value)
+	if err != nil {
+		return 0, err
+	}
+	if err = db.write(key, *NewValueMessage(creationTime, sequenceNumber, arrivalTime, value)); err != nil {
+		return 0, err
+	}
+	return sequenceNumber, nil
+}
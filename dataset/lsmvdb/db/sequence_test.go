@@ -0,0 +1,129 @@
+package db
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDB_AssignSequenceNumbers(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 512, AssignSequenceNumbers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for j := 1; j <= 50; j++ {
+		if err := db.Put(1, 0, ValidTime(j*10), "assigned"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a sensor-assigned number is stored as given, and the assigned numbers continue after it
+	if err := db.Put(1, 60, 600, "sensor"); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := db.Append(1, 610, "assigned")
+	if err != nil || seq != 61 {
+		t.Fatalf("Append(1, 610) = %d %v, want 61", seq, err)
+	}
+	if _, err := db.Append(1, 610, "stale"); err == nil {
+		t.Fatal("Append with a stale creation time succeeded")
+	} else if _, ok := err.(CreationTimeNotIncreasing); !ok {
+		t.Fatalf("Append with a stale creation time = %v, want CreationTimeNotIncreasing", err)
+	}
+	if seq, err := db.Append(2, 5, "new key"); err != nil || seq != 1 {
+		t.Fatalf("Append(2, 5) = %d %v, want 1", seq, err)
+	}
+
+	for j := 1; j < 50; j++ {
+		message, status, next, err := db.Get(1, ValidTime(j*10))
+		if err != nil || message.SequenceNumber() != SequenceNumber(j) || status != OK || next != SequenceNumber(j+1) {
+			t.Fatalf("Get(1, %d) = %s %s %d %v, want version %d OK", j*10, message, status, next, err, j)
+		}
+	}
+	if _, status, next, _ := db.Get(1, 500); status != HOLE || next != 60 {
+		t.Fatalf("Get(1, 500) = %s %d, want HOLE 60", status, next)
+	}
+}
+
+func TestDB_AppendConcurrently(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const writers, appends = 4, 50
+	var clock uint64
+	var mu sync.Mutex
+	assigned := make(map[SequenceNumber]bool)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < appends; {
+				seq, err := db.Append(1, ValidTime(atomic.AddUint64(&clock, 1)), "value")
+				if _, ok := err.(CreationTimeNotIncreasing); ok {
+					// another writer appended a later version first
+					continue
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if assigned[seq] {
+					t.Errorf("sequence number %d assigned twice", seq)
+				}
+				assigned[seq] = true
+				mu.Unlock()
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+	for seq := SequenceNumber(1); seq <= writers*appends; seq++ {
+		if !assigned[seq] {
+			t.Fatalf("sequence number %d has not been assigned", seq)
+		}
+	}
+}
+
+func TestDB_AppendAfterDeletedVersions(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 512, LevelRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for j := 1; j <= 3; j++ {
+		if _, err := db.Append(1, ValidTime(j*10), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the number of a deleted version is not assigned again
+	if err := db.Delete(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err := db.Append(1, 40, "value"); err != nil || seq != 4 {
+		t.Fatalf("Append(1, 40) after Delete(1, 3) = %d %v, want 4", seq, err)
+	}
+	if err := db.Delete(1, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// nor once the compactions have dropped the deleted version and its tombstone
+	for j := 1; j <= 200; j++ {
+		if err := db.Put(2, SequenceNumber(j), ValidTime(j*10), "filler"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records, err := db.records(1); err != nil {
+		t.Fatal(err)
+	} else if n := len(records); n != 2 || records[n-1].sequenceNumber != 2 {
+		t.Fatalf("key 1 holds %d records, want the versions 1 and 2", n)
+	}
+	if seq, err := db.Append(1, 50, "value"); err != nil || seq != 5 {
+		t.Fatalf("Append(1, 50) after compacting Delete(1, 4) = %d %v, want 5", seq, err)
+	}
+}
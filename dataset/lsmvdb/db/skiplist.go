@@ -1 +1,148 @@
 package db
+
+import "math/rand"
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 4 // a node reaches the next level with probability 1/skipListP
+)
+
+// SkipList is an ordered collection of elements of type T.
+// The order is defined by a comparison function returning a negative number, zero or a positive number
+// when its first argument is less than, equal to or greater than the second one.
+// Elements comparing equal are stored only once.
+//
+// A SkipList is not safe for concurrent use.
+type SkipList[T any] struct {
+	compare func(a, b T) int
+	head    SkipListNode[T] // sentinel node preceding the first element
+	tail    *SkipListNode[T]
+	level   int
+	length  int
+}
+
+// SkipListNode holds an element of a SkipList.
+type SkipListNode[T any] struct {
+	value T
+	next  []*SkipListNode[T]
+	prev  *SkipListNode[T]
+}
+
+func NewSkipList[T any](compare func(a, b T) int) *SkipList[T] {
+	l := &SkipList[T]{compare: compare, level: 1}
+	l.head.next = make([]*SkipListNode[T], skipListMaxLevel)
+	return l
+}
+
+// Value returns the element held by the node.
+func (n *SkipListNode[T]) Value() T {
+	return n.value
+}
+
+// Next returns the node of the succeeding element, or nil if n holds the last element.
+func (n *SkipListNode[T]) Next() *SkipListNode[T] {
+	return n.next[0]
+}
+
+// Prev returns the node of the preceding element, or nil if n holds the first element.
+func (n *SkipListNode[T]) Prev() *SkipListNode[T] {
+	return n.prev
+}
+
+func (l *SkipList[T]) Len() int {
+	return l.length
+}
+
+// First returns the node of the smallest element, or nil if the list is empty.
+func (l *SkipList[T]) First() *SkipListNode[T] {
+	return l.head.next[0]
+}
+
+// Last returns the node of the largest element, or nil if the list is empty.
+func (l *SkipList[T]) Last() *SkipListNode[T] {
+	return l.tail
+}
+
+// findPredecessors fills update with the last node on each level whose element is less than value,
+// and returns the node of the first element greater than or equal to value.
+func (l *SkipList[T]) findPredecessors(value T, update *[skipListMaxLevel]*SkipListNode[T]) *SkipListNode[T] {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.compare(x.next[i].value, value) < 0 {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// Seek returns the node of the smallest element greater than or equal to value, or nil if there is none.
+func (l *SkipList[T]) Seek(value T) *SkipListNode[T] {
+	return l.findPredecessors(value, nil)
+}
+
+// Insert adds value to the list. If the list holds an element equal to value, the element is replaced,
+// and Insert reports true.
+func (l *SkipList[T]) Insert(value T) (replaced bool) {
+	var update [skipListMaxLevel]*SkipListNode[T]
+	x := l.findPredecessors(value, &update)
+	if x != nil && l.compare(x.value, value) == 0 {
+		x.value = value
+		return true
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = &l.head
+		}
+		l.level = level
+	}
+	x = &SkipListNode[T]{value: value, next: make([]*SkipListNode[T], level)}
+	for i := 0; i < level; i++ {
+		x.next[i] = update[i].next[i]
+		update[i].next[i] = x
+	}
+	if update[0] != &l.head {
+		x.prev = update[0]
+	}
+	if x.next[0] != nil {
+		x.next[0].prev = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+	return false
+}
+
+// Delete removes the element equal to value and reports whether it was found.
+func (l *SkipList[T]) Delete(value T) bool {
+	var update [skipListMaxLevel]*SkipListNode[T]
+	x := l.findPredecessors(value, &update)
+	if x == nil || l.compare(x.value, value) != 0 {
+		return false
+	}
+	for i := 0; i < len(x.next); i++ {
+		update[i].next[i] = x.next[i]
+	}
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	} else {
+		l.tail = x.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
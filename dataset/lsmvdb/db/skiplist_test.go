@@ -0,0 +1,118 @@
+package db
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func compareInts(a, b int) int {
+	return a - b
+}
+
+func TestSkipList(t *testing.T) {
+	l := NewSkipList(compareInts)
+	if l.First() != nil || l.Last() != nil || l.Seek(0) != nil {
+		t.Fatal("empty list has elements")
+	}
+	values := rand.Perm(1000)
+	for _, v := range values {
+		if l.Insert(v * 2) {
+			t.Fatalf("Insert(%d) replaced an element", v*2)
+		}
+	}
+	if !l.Insert(10) {
+		t.Fatal("Insert(10) did not replace the element")
+	}
+	if l.Len() != 1000 {
+		t.Fatalf("Len() = %d, want 1000", l.Len())
+	}
+
+	// forward and backward iteration
+	i := 0
+	for n := l.First(); n != nil; n = n.Next() {
+		if n.Value() != i*2 {
+			t.Fatalf("element %d = %d, want %d", i, n.Value(), i*2)
+		}
+		i++
+	}
+	for n := l.Last(); n != nil; n = n.Prev() {
+		i--
+		if n.Value() != i*2 {
+			t.Fatalf("element %d = %d, want %d", i, n.Value(), i*2)
+		}
+	}
+
+	for _, c := range []struct{ seek, want int }{{-1, 0}, {0, 0}, {1, 2}, {998, 998}, {1997, 1998}} {
+		if n := l.Seek(c.seek); n == nil || n.Value() != c.want {
+			t.Fatalf("Seek(%d) = %v, want %d", c.seek, n, c.want)
+		}
+	}
+	if n := l.Seek(1999); n != nil {
+		t.Fatalf("Seek(1999) = %d, want none", n.Value())
+	}
+
+	// delete the odd multiples of 2
+	for _, v := range values {
+		if v%2 == 1 && !l.Delete(v*2) {
+			t.Fatalf("Delete(%d) did not find the element", v*2)
+		}
+	}
+	if l.Delete(2) {
+		t.Fatal("Delete(2) found a deleted element")
+	}
+	var got []int
+	for n := l.First(); n != nil; n = n.Next() {
+		got = append(got, n.Value())
+	}
+	if len(got) != 500 || l.Len() != 500 || !sort.IntsAreSorted(got) || got[0] != 0 || got[499] != 1996 {
+		t.Fatalf("unexpected elements after deletion: %d elements, Len() = %d", len(got), l.Len())
+	}
+	if l.Last().Value() != 1996 || l.Last().Next() != nil || l.First().Prev() != nil {
+		t.Fatal("broken links after deletion")
+	}
+	if n := l.Seek(1994); n.Prev().Value() != 1992 {
+		t.Fatalf("Seek(1994).Prev() = %d, want 1992", n.Prev().Value())
+	}
+}
+
+func TestMemtable_LargeTimesAndSharedCreationTime(t *testing.T) {
+	mem := NewMemtable(0)
+	// above 2^53, float64 can not tell these creation times apart
+	base := ValidTime(1<<60 + 1)
+	if err := mem.Put(1, 1, base, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Put(1, 2, base+1, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Put(1, 3, base+1, "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Put(1, 4, base+2, "v4"); err != nil {
+		t.Fatal(err)
+	}
+
+	if versions, _ := mem.versions(1); len(versions) != 4 {
+		t.Fatalf("memtable holds %d versions, want 4", len(versions))
+	}
+	for _, c := range []struct {
+		time   ValidTime
+		value  string
+		status Status
+		next   SequenceNumber
+	}{
+		{base - 1, "", NOTFOUND, 1},
+		{base, "v1", OK, 2},
+		{base + 1, "v3", OK, 4},
+		{base + 2, "v4", ODV, 0},
+	} {
+		message, status, next, err := mem.Get(1, c.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.value != c.value || status != c.status || next != c.next {
+			t.Fatalf("Get(1, %d) = %s %s %d, want %s %s %d", c.time, message, status, next, c.value, c.status, c.next)
+		}
+	}
+}
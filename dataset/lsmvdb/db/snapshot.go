@@ -0,0 +1,55 @@
+package db
+
+import (
+	"math"
+	"sort"
+)
+
+// Snapshot returns the version of every key in the database valid at time, classified as by Get,
+// together with the number of keys of each status.
+func (db *DB) Snapshot(time ValidTime) (results map[Key]*Result, summary map[Status]int, err error) {
+	return db.SnapshotRange(time, 0, math.MaxUint64)
+}
+
+// SnapshotRange is like Snapshot, but restricted to the keys in [lo, hi].
+// A key whose versions are out of order is reported with status ERROR, and a quarantined key with status QUARANTINED,
+// instead of failing the snapshot.
+func (db *DB) SnapshotRange(time ValidTime, lo, hi Key) (results map[Key]*Result, summary map[Status]int, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	results = make(map[Key]*Result)
+	summary = make(map[Status]int)
+	for _, key := range db.keys(lo, hi) {
+		message, status, nextSequence, err := db.get(key, time)
+		switch err.(type) {
+		case nil, SequenceOutOfOrder, KeyQuarantined:
+		default:
+			return nil, nil, err
+		}
+		result := &Result{status: status, nextSequence: nextSequence}
+		if err == nil && status != NOTFOUND && status != EXPIRED {
+			result.message = &message
+		}
+		results[key] = result
+		summary[status]++
+	}
+	return results, summary, nil
+}
+
+// keys returns the keys in [lo, hi] held by any component, in ascending order.
+func (db *DB) keys(lo, hi Key) []Key {
+	set := make(map[Key]bool)
+	for _, c := range db.components() {
+		for _, key := range c.keys() {
+			if lo <= key && key <= hi {
+				set[key] = true
+			}
+		}
+	}
+	keys := make([]Key, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
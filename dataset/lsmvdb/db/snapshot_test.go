@@ -0,0 +1,60 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	diskDB, err := Open(t.TempDir(), &Options{MemtableSize: 512, LevelRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diskDB.Close()
+	for j := 1; j < 50; j++ {
+		for k := 1; k <= 20; k++ {
+			// key k starts at version k, and misses every version divisible by k
+			if j < k || (k > 1 && j%k == 0) {
+				continue
+			}
+			if err := diskDB.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, time := range []ValidTime{0, 55, 100, 255, 490, 1000} {
+		results, summary, err := diskDB.Snapshot(time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 20 {
+			t.Fatalf("Snapshot(%d) holds %d keys, want 20", time, len(results))
+		}
+		counts := make(map[Status]int)
+		for k := 1; k <= 20; k++ {
+			message, status, nextSequence, _ := diskDB.Get(Key(k), time)
+			r := results[Key(k)]
+			if r.Status() != status || r.NextSequence() != nextSequence || (r.Message() != nil && *r.Message() != message) {
+				t.Fatalf("Snapshot(%d) of key %d = %v, want %s %s %d", time, k, r, message, status, nextSequence)
+			}
+			counts[status]++
+		}
+		for _, status := range []Status{OK, ODV, HOLE, NOTFOUND} {
+			if summary[status] != counts[status] {
+				t.Fatalf("Snapshot(%d) counts %d keys with status %s, want %d", time, summary[status], status, counts[status])
+			}
+		}
+	}
+
+	results, summary, err := diskDB.SnapshotRange(255, 5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || summary[OK]+summary[HOLE] != 5 {
+		t.Fatalf("SnapshotRange(255, 5, 9) = %d keys, summary %v", len(results), summary)
+	}
+	if results[Key(5)].Status() != HOLE || results[Key(6)].Status() != OK {
+		t.Fatalf("SnapshotRange(255, 5, 9) = %s for key 5, %s for key 6, want HOLE and OK", results[Key(5)].Status(), results[Key(6)].Status())
+	}
+}
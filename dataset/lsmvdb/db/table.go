@@ -0,0 +1,551 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+// A table is an immutable file holding versions sorted by key, then by creation time.
+// The file is laid out as
+//
+//	data block... | index block | meta block | footer
+//
+// A data block is a sequence of entries followed by the CRC-32C of the entries:
+//
+//	entry: key uvarint | creationTime uvarint | sequenceNumber uvarint | arrivalTime uvarint | kind uvarint | valueType uvarint | correction | value
+//
+// A tombstone or an amendment stores its correction as written by appendCorrection, which is empty for a version
+// as written by Put.
+// The encoding of the value depends on its type. A string or a blob is stored as len uvarint | value [len]byte.
+// Numbers are compressed against the preceding number of the same type and key in the block, or 0 for the first:
+// an int64 is stored as the varint of the difference, and a float64 as the XOR of the bits (see appendFloat).
+//
+// The versions of a key are stored next to each other in the order of their creation times,
+// and a key may span several blocks. The index block holds one handle per data block and is
+// followed by its own CRC-32C:
+//
+//	numberOfBlocks uvarint | (lastKey uvarint | lastCreationTime uvarint | offset uvarint | length uvarint)...
+//
+// The meta block records the level the table was written for and the sequence spans of every key
+// (see seqSpans), followed by its CRC-32C:
+//
+//	level uvarint | numberOfKeys uvarint | (key uvarint | numberOfSpans uvarint | (first uvarint | last-first uvarint)...)...
+//
+// The footer has a fixed size:
+//
+//	indexOffset uint64 | indexLength uint64 | metaOffset uint64 | metaLength uint64 | formatVersion uint32 | magic uint64
+const (
+	tableMagic         uint64 = 0x6c62742d62647673 // "svdb-tbl"
+	tableFormatVersion uint32 = 1
+	tableFooterSize           = 44
+	defaultBlockSize          = 4 << 10
+)
+
+// blockHandle locates a data block and records the position of its last entry.
+type blockHandle struct {
+	lastKey  Key
+	lastTime ValidTime
+	offset   uint64
+	length   uint64 // including the checksum
+}
+
+// tableWriter writes a table file. Versions must be added in the order of the table.
+type tableWriter struct {
+	file      *os.File
+	blockSize int
+	block     []byte
+	offset    uint64
+	index     []blockHandle
+	lastKey   Key
+	lastTime  ValidTime
+	level     int
+	spans     map[Key]seqSpans
+	// the bits of the preceding int64 and float64 values of lastKey in the block
+	lastInt   uint64
+	lastFloat uint64
+}
+
+func newTableWriter(filename string, blockSize, level int) (*tableWriter, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	return &tableWriter{file: file, blockSize: blockSize, level: level, spans: make(map[Key]seqSpans)}, nil
+}
+
+func (w *tableWriter) Add(key Key, message Message) error {
+	if len(w.block) == 0 || key != w.lastKey {
+		w.lastInt, w.lastFloat = 0, 0
+	}
+	w.block = binary.AppendUvarint(w.block, uint64(key))
+	w.block = binary.AppendUvarint(w.block, uint64(message.creationTime))
+	w.block = binary.AppendUvarint(w.block, uint64(message.sequenceNumber))
+	w.block = binary.AppendUvarint(w.block, uint64(message.arrivalTime))
+	w.block = binary.AppendUvarint(w.block, uint64(message.kind))
+	w.block = binary.AppendUvarint(w.block, uint64(message.valueType))
+	w.block = appendCorrection(w.block, message)
+	switch message.valueType {
+	case TypeInt64:
+		v := binary.LittleEndian.Uint64([]byte(message.value))
+		w.block = binary.AppendVarint(w.block, int64(v-w.lastInt))
+		w.lastInt = v
+	case TypeFloat64:
+		v := binary.LittleEndian.Uint64([]byte(message.value))
+		w.block = appendFloat(w.block, v, w.lastFloat)
+		w.lastFloat = v
+	default:
+		w.block = binary.AppendUvarint(w.block, uint64(len(message.value)))
+		w.block = append(w.block, message.value...)
+	}
+	w.lastKey, w.lastTime = key, message.creationTime
+	w.spans[key] = w.spans[key].add(message.sequenceNumber)
+	if len(w.block) >= w.blockSize {
+		return w.finishBlock()
+	}
+	return nil
+}
+
+// appendFloat appends the float64 with bits v, compressed against the bits last of the preceding one: the XOR of both
+// is stored as a byte holding the number of its leading zero bytes in the high nibble and the number of its
+// meaningful bytes in the low nibble, followed by the meaningful bytes, most significant first. The trailing zero
+// bytes are implied. Similar numbers share their sign, their exponent and the top of their mantissa, so a reading
+// takes at most 8 bytes unless its sign or the top of its exponent changes, and a repeated one takes a single byte.
+func appendFloat(buf []byte, v, last uint64) []byte {
+	xor := v ^ last
+	if xor == 0 {
+		return append(buf, 8<<4)
+	}
+	leading, trailing := bits.LeadingZeros64(xor)/8, bits.TrailingZeros64(xor)/8
+	meaningful := 8 - leading - trailing
+	buf = append(buf, byte(leading<<4|meaningful))
+	for i := meaningful - 1; i >= 0; i-- {
+		buf = append(buf, byte(xor>>(8*(trailing+i))))
+	}
+	return buf
+}
+
+func (w *tableWriter) finishBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	w.block = binary.LittleEndian.AppendUint32(w.block, crc32.Checksum(w.block, crcTable))
+	if _, err := w.file.Write(w.block); err != nil {
+		return err
+	}
+	w.index = append(w.index, blockHandle{lastKey: w.lastKey, lastTime: w.lastTime, offset: w.offset, length: uint64(len(w.block))})
+	w.offset += uint64(len(w.block))
+	w.block = w.block[:0]
+	return nil
+}
+
+// AddSpans records sequence numbers written to key that are not necessarily stored in the table,
+// e.g., those of versions dropped by a compaction.
+func (w *tableWriter) AddSpans(key Key, spans seqSpans) {
+	w.spans[key] = w.spans[key].union(spans)
+}
+
+// Finish writes the index block, the meta block and the footer, syncs the file and closes it.
+func (w *tableWriter) Finish() (err error) {
+	defer func() {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err = w.finishBlock(); err != nil {
+		return err
+	}
+	index := binary.AppendUvarint(nil, uint64(len(w.index)))
+	for _, handle := range w.index {
+		index = binary.AppendUvarint(index, uint64(handle.lastKey))
+		index = binary.AppendUvarint(index, uint64(handle.lastTime))
+		index = binary.AppendUvarint(index, handle.offset)
+		index = binary.AppendUvarint(index, handle.length)
+	}
+	index = binary.LittleEndian.AppendUint32(index, crc32.Checksum(index, crcTable))
+
+	keys := make([]Key, 0, len(w.spans))
+	for key := range w.spans {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	meta := binary.AppendUvarint(nil, uint64(w.level))
+	meta = binary.AppendUvarint(meta, uint64(len(keys)))
+	for _, key := range keys {
+		meta = binary.AppendUvarint(meta, uint64(key))
+		meta = binary.AppendUvarint(meta, uint64(len(w.spans[key])))
+		for _, span := range w.spans[key] {
+			meta = binary.AppendUvarint(meta, uint64(span.first))
+			meta = binary.AppendUvarint(meta, uint64(span.last-span.first))
+		}
+	}
+	meta = binary.LittleEndian.AppendUint32(meta, crc32.Checksum(meta, crcTable))
+
+	footer := binary.LittleEndian.AppendUint64(nil, w.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, w.offset+uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(meta)))
+	footer = binary.LittleEndian.AppendUint32(footer, tableFormatVersion)
+	footer = binary.LittleEndian.AppendUint64(footer, tableMagic)
+	buf := append(index, meta...)
+	if _, err = w.file.Write(append(buf, footer...)); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Abort discards an unfinished table.
+func (w *tableWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// table reads a table file. Only the index and the meta block are kept in memory; data blocks are read on demand.
+type table struct {
+	number uint64
+	file   *os.File
+	index  []blockHandle
+	level  int
+	spans  map[Key]seqSpans
+}
+
+func openTable(filename string, number uint64) (*table, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{number: number, file: file}
+	if err = t.readIndex(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *table) readIndex() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < tableFooterSize {
+		return CorruptedFile{t.file.Name(), "file too short"}
+	}
+	footer := make([]byte, tableFooterSize)
+	if _, err = t.file.ReadAt(footer, info.Size()-tableFooterSize); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(footer[36:]) != tableMagic {
+		return CorruptedFile{t.file.Name(), "bad magic number"}
+	}
+	if version := binary.LittleEndian.Uint32(footer[32:]); version != tableFormatVersion {
+		return UnsupportedFormatVersion{t.file.Name(), version}
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexLength := binary.LittleEndian.Uint64(footer[8:])
+	metaOffset := binary.LittleEndian.Uint64(footer[16:])
+	metaLength := binary.LittleEndian.Uint64(footer[24:])
+	if indexOffset+indexLength != metaOffset || metaOffset+metaLength+tableFooterSize != uint64(info.Size()) {
+		return CorruptedFile{t.file.Name(), "bad block handle in footer"}
+	}
+	index, err := t.readBlock(indexOffset, indexLength)
+	if err != nil {
+		return err
+	}
+
+	d := decoder{buf: index}
+	t.index = make([]blockHandle, d.uvarint())
+	for i := range t.index {
+		t.index[i] = blockHandle{
+			lastKey:  Key(d.uvarint()),
+			lastTime: ValidTime(d.uvarint()),
+			offset:   d.uvarint(),
+			length:   d.uvarint(),
+		}
+	}
+	if d.err != nil {
+		return CorruptedFile{t.file.Name(), "index block: " + d.err.Error()}
+	}
+
+	meta, err := t.readBlock(metaOffset, metaLength)
+	if err != nil {
+		return err
+	}
+	d = decoder{buf: meta}
+	t.level = int(d.uvarint())
+	numberOfKeys := d.uvarint()
+	t.spans = make(map[Key]seqSpans)
+	for i := uint64(0); i < numberOfKeys && d.err == nil; i++ {
+		key := Key(d.uvarint())
+		spans := make(seqSpans, d.uvarint())
+		for j := range spans {
+			spans[j].first = SequenceNumber(d.uvarint())
+			spans[j].last = spans[j].first + SequenceNumber(d.uvarint())
+		}
+		t.spans[key] = spans
+	}
+	if d.err != nil {
+		return CorruptedFile{t.file.Name(), "meta block: " + d.err.Error()}
+	}
+	return nil
+}
+
+// readBlock reads the block at offset and verifies its checksum. The returned block excludes the checksum.
+func (t *table) readBlock(offset, length uint64) ([]byte, error) {
+	if length < 4 {
+		return nil, CorruptedFile{t.file.Name(), "bad block handle"}
+	}
+	buf := make([]byte, length)
+	if _, err := t.file.ReadAt(buf, int64(offset)); err != nil {
+		if err == io.EOF {
+			return nil, CorruptedFile{t.file.Name(), "bad block handle"}
+		}
+		return nil, err
+	}
+	block := buf[:length-4]
+	if crc32.Checksum(block, crcTable) != binary.LittleEndian.Uint32(buf[length-4:]) {
+		return nil, CorruptedFile{t.file.Name(), "block checksum mismatch"}
+	}
+	return block, nil
+}
+
+// decoder reads varint-encoded fields from a buffer and records the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var (
+	errTruncated    = errors.New("truncated data")
+	errInvalidFloat = errors.New("invalid float header")
+)
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// float reads the XOR of a float64 written by appendFloat.
+func (d *decoder) float() uint64 {
+	header := d.bytes(1)
+	if d.err != nil {
+		return 0
+	}
+	leading, meaningful := int(header[0]>>4), int(header[0]&0xf)
+	if leading+meaningful > 8 {
+		d.err = errInvalidFloat
+		return 0
+	}
+	var xor uint64
+	for _, b := range d.bytes(meaningful) {
+		xor = xor<<8 | uint64(b)
+	}
+	return xor << (8 * (8 - leading - meaningful))
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+type tableEntry struct {
+	key     Key
+	message Message
+}
+
+// readEntries reads and decodes the i-th data block.
+func (t *table) readEntries(i int) ([]tableEntry, error) {
+	block, err := t.readBlock(t.index[i].offset, t.index[i].length)
+	if err != nil {
+		return nil, err
+	}
+	var entries []tableEntry
+	var lastInt, lastFloat uint64
+	d := decoder{buf: block}
+	for len(d.buf) > 0 && d.err == nil {
+		var e tableEntry
+		e.key = Key(d.uvarint())
+		if n := len(entries); n == 0 || entries[n-1].key != e.key {
+			lastInt, lastFloat = 0, 0
+		}
+		e.message.creationTime = ValidTime(d.uvarint())
+		e.message.sequenceNumber = SequenceNumber(d.uvarint())
+		e.message.arrivalTime = ValidTime(d.uvarint())
+		e.message.kind = messageKind(d.uvarint())
+		e.message.valueType = ValueType(d.uvarint())
+		d.correction(&e.message)
+		switch e.message.valueType {
+		case TypeInt64:
+			lastInt += uint64(d.varint())
+			e.message.value = string(binary.LittleEndian.AppendUint64(nil, lastInt))
+		case TypeFloat64:
+			lastFloat ^= d.float()
+			e.message.value = string(binary.LittleEndian.AppendUint64(nil, lastFloat))
+		default:
+			e.message.value = string(d.bytes(int(d.uvarint())))
+		}
+		entries = append(entries, e)
+	}
+	if d.err != nil {
+		return nil, CorruptedFile{t.file.Name(), "data block: " + d.err.Error()}
+	}
+	return entries, nil
+}
+
+// after reports whether the position (k, ct) in the table comes after the version of key valid at time.
+func after(k Key, ct ValidTime, key Key, time ValidTime) bool {
+	return k > key || (k == key && ct > time)
+}
+
+// lookup returns the version of key valid at time and its successor in the table.
+func (t *table) lookup(key Key, time ValidTime) (message, next *Message, err error) {
+	// b is the first block holding an entry after the version valid at time
+	b := sort.Search(len(t.index), func(i int) bool {
+		return after(t.index[i].lastKey, t.index[i].lastTime, key, time)
+	})
+	if b < len(t.index) {
+		entries, err := t.readEntries(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		i := sort.Search(len(entries), func(i int) bool {
+			return after(entries[i].key, entries[i].message.creationTime, key, time)
+		})
+		if entries[i].key == key {
+			next = &entries[i].message
+		}
+		if i > 0 {
+			if entries[i-1].key == key {
+				message = &entries[i-1].message
+			}
+			return message, next, nil
+		}
+	}
+	// the version valid at time, if any, is the last entry of the previous block
+	if b > 0 && t.index[b-1].lastKey == key {
+		entries, err := t.readEntries(b - 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		message = &entries[len(entries)-1].message
+	}
+	return message, next, nil
+}
+
+// keys returns the keys in the table. The meta block records the sequence spans of every key in the table,
+// so it serves as the key directory.
+func (t *table) keys() []Key {
+	keys := make([]Key, 0, len(t.spans))
+	for key := range t.spans {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// versions returns the versions of key in the table in the order of their creation times.
+func (t *table) versions(key Key) ([]Message, error) {
+	// b is the first block that may hold key
+	b := sort.Search(len(t.index), func(i int) bool { return t.index[i].lastKey >= key })
+	var versions []Message
+	for ; b < len(t.index); b++ {
+		entries, err := t.readEntries(b)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.key > key {
+				return versions, nil
+			}
+			if e.key == key {
+				versions = append(versions, e.message)
+			}
+		}
+	}
+	return versions, nil
+}
+
+func (t *table) Close() error {
+	return t.file.Close()
+}
+
+// tableIterator iterates over the entries of a table in order.
+type tableIterator struct {
+	table   *table
+	block   int
+	entries []tableEntry
+	err     error
+}
+
+func (t *table) newIterator() *tableIterator {
+	return &tableIterator{table: t, block: -1}
+}
+
+// Next advances the iterator. It returns false when the table is exhausted or an error occurs.
+func (it *tableIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.entries) > 1 {
+		it.entries = it.entries[1:]
+		return true
+	}
+	for it.block+1 < len(it.table.index) {
+		it.block++
+		it.entries, it.err = it.table.readEntries(it.block)
+		if it.err != nil {
+			return false
+		}
+		if len(it.entries) > 0 {
+			return true
+		}
+	}
+	it.entries = nil
+	return false
+}
+
+func (it *tableIterator) Key() Key {
+	return it.entries[0].key
+}
+
+func (it *tableIterator) Message() Message {
+	return it.entries[0].message
+}
+
+func (it *tableIterator) Err() error {
+	return it.err
+}
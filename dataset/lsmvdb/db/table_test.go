@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTable writes a table holding keys 1..numberOfKeys, each with versions at creation times 10, 20, ...
+func writeTestTable(t *testing.T, filename string, numberOfKeys, numberOfVersions int) {
+	t.Helper()
+	w, err := newTableWriter(filename, 256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 1; k <= numberOfKeys; k++ {
+		for j := 1; j <= numberOfVersions; j++ {
+			message := Message{creationTime: ValidTime(j * 10), sequenceNumber: SequenceNumber(j), value: fmt.Sprintf("value %d-%d", k, j)}
+			if err := w.Add(Key(k), message); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTable_RoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "000001.sst")
+	writeTestTable(t, filename, 20, 30)
+	table, err := openTable(filename, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	if len(table.index) < 2 {
+		t.Fatalf("expected several blocks, got %d", len(table.index))
+	}
+
+	// the iterator yields every entry in order
+	it := table.newIterator()
+	count := 0
+	for it.Next() {
+		k, j := count/30+1, count%30+1
+		message := it.Message()
+		if it.Key() != Key(k) || message.creationTime != ValidTime(j*10) || message.sequenceNumber != SequenceNumber(j) ||
+			message.value != fmt.Sprintf("value %d-%d", k, j) {
+			t.Fatalf("entry %d = %d %s", count, it.Key(), message)
+		}
+		count++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if count != 20*30 {
+		t.Fatalf("iterated %d entries, want %d", count, 20*30)
+	}
+
+	// lookups reproduce the classification of the memtable
+	mem := NewMemtable(0)
+	for k := 1; k <= 20; k++ {
+		for j := 1; j <= 30; j++ {
+			if err := mem.Put(Key(k), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d-%d", k, j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for k := 0; k <= 21; k++ {
+		for time := 0; time <= 320; time += 5 {
+			message, next, err := table.lookup(Key(k), ValidTime(time))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m1, s1, n1, _ := mem.Get(Key(k), ValidTime(time))
+			m2, s2, n2, _ := resolve(message, next, nil)
+			if m1 != m2 || s1 != s2 || n1 != n2 {
+				t.Fatalf("lookup(%d, %d) = %s %s %d, want %s %s %d", k, time, m2, s2, n2, m1, s1, n1)
+			}
+		}
+	}
+}
+
+func TestTable_Corruption(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "000001.sst")
+	writeTestTable(t, filename, 5, 10)
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[3] ^= 0xff // a byte in the first data block
+	if err := os.WriteFile(filename, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := openTable(filename, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	_, _, err = table.lookup(Key(1), ValidTime(10))
+	var corrupted CorruptedFile
+	if !errors.As(err, &corrupted) {
+		t.Fatalf("lookup on a corrupted block returned %v", err)
+	}
+}
+
+func TestAppendFloat(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		value   func(j int) float64
+		maxSize float64 // the average number of bytes per value, below the 8 bytes of the raw bits
+	}{
+		{"noisy", func(j int) float64 { return 20 + float64(j*7919%1000)/997 }, 7.1},
+		{"steps", func(j int) float64 { return 20 + float64(j)*0.1 }, 7.1},
+		{"constant", func(int) float64 { return 20.3 }, 1.01},
+		{"integral", func(j int) float64 { return float64(j % 50) }, 2.5},
+	} {
+		var buf []byte
+		var last uint64
+		for j := 1; j <= 1000; j++ {
+			v := math.Float64bits(c.value(j))
+			buf = appendFloat(buf, v, last)
+			last = v
+		}
+		if size := float64(len(buf)) / 1000; size > c.maxSize {
+			t.Fatalf("%s: %.2f bytes per value, want at most %.2f", c.name, size, c.maxSize)
+		}
+		d := decoder{buf: buf}
+		last = 0
+		for j := 1; j <= 1000; j++ {
+			last ^= d.float()
+			if d.err != nil || math.Float64frombits(last) != c.value(j) {
+				t.Fatalf("%s: value %d = %v %v, want %v", c.name, j, math.Float64frombits(last), d.err, c.value(j))
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ValueType is the type of the payload of a version.
+type ValueType uint8
+
+const (
+	TypeString  ValueType = iota // text, as written by Put
+	TypeBytes                    // a binary blob, e.g., encoded by a Codec
+	TypeInt64                    // a signed integer
+	TypeFloat64                  // a floating-point number
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeBytes:
+		return "bytes"
+	case TypeInt64:
+		return "int64"
+	case TypeFloat64:
+		return "float64"
+	default:
+		return "unknown"
+	}
+}
+
+// Value is a typed payload of a version. Tables store numeric values compressed against the preceding value
+// of the key, and Aggregate summarizes them.
+type Value struct {
+	typ ValueType
+	// data is the encoding of the value: the bytes of a string or a blob,
+	// or the 8 little-endian bytes of a number
+	data string
+}
+
+func StringValue(s string) Value {
+	return Value{typ: TypeString, data: s}
+}
+
+func BytesValue(b []byte) Value {
+	return Value{typ: TypeBytes, data: string(b)}
+}
+
+func Int64Value(i int64) Value {
+	return Value{typ: TypeInt64, data: string(binary.LittleEndian.AppendUint64(nil, uint64(i)))}
+}
+
+func Float64Value(f float64) Value {
+	return Value{typ: TypeFloat64, data: string(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))}
+}
+
+func (v Value) Type() ValueType {
+	return v.typ
+}
+
+// Int64 returns the value of an int64 payload. ok is false for a payload of any other type.
+func (v Value) Int64() (i int64, ok bool) {
+	if v.typ != TypeInt64 || len(v.data) != 8 {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64([]byte(v.data))), true
+}
+
+// Float64 returns the value of a numeric payload, converting an int64 one. ok is false for a payload
+// that is not numeric.
+func (v Value) Float64() (f float64, ok bool) {
+	if i, ok := v.Int64(); ok {
+		return float64(i), true
+	}
+	if v.typ != TypeFloat64 || len(v.data) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64([]byte(v.data))), true
+}
+
+// Bytes returns the encoding of the value, i.e., the bytes of a string or a blob.
+func (v Value) Bytes() []byte {
+	return []byte(v.data)
+}
+
+func (v Value) String() string {
+	switch v.typ {
+	case TypeString:
+		return v.data
+	case TypeBytes:
+		return fmt.Sprintf("%x", v.data)
+	default:
+		if i, ok := v.Int64(); ok {
+			return fmt.Sprint(i)
+		}
+		f, _ := v.Float64()
+		return fmt.Sprint(f)
+	}
+}
+
+// Codec converts payloads of type T to blobs and back, for payloads without a built-in value type, e.g., vectors.
+type Codec[T any] interface {
+	Encode(v T) []byte
+	Decode(b []byte) (T, error)
+}
+
+// Encode returns v encoded by codec as a blob value.
+func Encode[T any](codec Codec[T], v T) Value {
+	return BytesValue(codec.Encode(v))
+}
+
+// Decode decodes the blob value v with codec. It fails with ValueTypeMismatch if v is not a blob.
+func Decode[T any](codec Codec[T], v Value) (T, error) {
+	if v.typ != TypeBytes {
+		var zero T
+		return zero, ValueTypeMismatch{TypeBytes, v.typ}
+	}
+	return codec.Decode(v.Bytes())
+}
+
+// Float64sCodec encodes a vector of float64 as the 8 little-endian bytes of each element.
+type Float64sCodec struct{}
+
+func (Float64sCodec) Encode(v []float64) []byte {
+	b := make([]byte, 0, 8*len(v))
+	for _, f := range v {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+	}
+	return b
+}
+
+func (Float64sCodec) Decode(b []byte) ([]float64, error) {
+	if len(b)%8 != 0 {
+		return nil, errTruncated
+	}
+	v := make([]float64, len(b)/8)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return v, nil
+}
+
+// Aggregate summarizes the numeric versions of a key.
+type Aggregate struct {
+	Count int // the number of numeric versions
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Mean returns the average of the versions, or NaN if there are none.
+func (a Aggregate) Mean() float64 {
+	if a.Count == 0 {
+		return math.NaN()
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Aggregate summarizes the numeric versions of key valid at some time in [lo, hi], i.e., those yielded by
+// NewVersionIterator. Int64 values are converted to float64, and versions of other types are skipped.
+func (db *DB) Aggregate(key Key, lo, hi ValidTime) (Aggregate, error) {
+	var a Aggregate
+	it := db.NewVersionIterator(key, lo, hi)
+	for it.Next() {
+		f, ok := it.Message().Payload().Float64()
+		if !ok {
+			continue
+		}
+		if a.Count == 0 || f < a.Min {
+			a.Min = f
+		}
+		if a.Count == 0 || f > a.Max {
+			a.Max = f
+		}
+		a.Sum += f
+		a.Count++
+	}
+	return a, it.Err()
+}
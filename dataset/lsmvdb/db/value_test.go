@@ -0,0 +1,80 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDB_TypedValues(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MemtableSize: 512, LevelRuns: 2}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(key Key, j int) Value {
+		switch key {
+		case 1:
+			return Int64Value(int64(j*j) - 1000)
+		case 2:
+			return Float64Value(20 + float64(j%7)*0.25)
+		case 3:
+			return Encode[[]float64](Float64sCodec{}, []float64{float64(j), -float64(j)})
+		default:
+			return StringValue("value")
+		}
+	}
+	for j := 1; j <= 100; j++ {
+		for k := Key(1); k <= 4; k++ {
+			if err := db.PutValue(k, SequenceNumber(j), ValidTime(j*10), 0, value(k, j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.AmendValue(2, 50, Float64Value(math.Inf(-1))); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for j := 1; j <= 100; j++ {
+			for k := Key(1); k <= 4; k++ {
+				want := value(k, j)
+				if k == 2 && j == 50 {
+					want = Float64Value(math.Inf(-1))
+				}
+				message, _, _, err := db.Get(k, ValidTime(j*10))
+				if err != nil || message.Payload() != want {
+					t.Fatalf("Get(%d, %d) = %s %v, want value %s", k, j*10, message, err, want)
+				}
+			}
+		}
+		message, _, _, _ := db.Get(3, 420)
+		if v, err := Decode[[]float64](Float64sCodec{}, message.Payload()); err != nil || len(v) != 2 || v[1] != -42 {
+			t.Fatalf("Decode = %v %v, want [42 -42]", v, err)
+		}
+		if _, err := Decode[[]float64](Float64sCodec{}, Int64Value(1)); err == nil {
+			t.Fatal("Decode of an int64 value succeeded")
+		}
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+
+	a, err := db.Aggregate(1, 15, 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != 3 || a.Min != -999 || a.Max != -991 || a.Sum != -2986 {
+		t.Fatalf("Aggregate(1, 15, 35) = %+v, want versions 1 to 3", a)
+	}
+	if a, _ := db.Aggregate(4, 0, OpenEnd); a.Count != 0 || !math.IsNaN(a.Mean()) {
+		t.Fatalf("Aggregate of strings = %+v, want no versions", a)
+	}
+}
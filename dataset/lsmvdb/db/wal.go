@@ -0,0 +1,151 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// The write-ahead log starts with a file header
+//
+//	magic uint32 | version uint32
+//
+// followed by a sequence of records. Each record is laid out as
+//
+//	checksum uint32 | length uint32 | payload [length]byte
+//
+// where checksum is the CRC-32C of the payload and the payload holds
+//
+//	key uint64 | sequenceNumber uint64 | creationTime uint64 | arrivalTime uint64 | kind uint8 | valueType uint8 | correction | value
+//
+// The fixed-size integers are little-endian. A tombstone or an amendment stores its correction as written by
+// appendCorrection, which is empty for a version as written by Put. The value runs to the end of the payload.
+const (
+	walFileHeaderSize = 8
+	walMagic          = 0x4c41574c // "LWAL"
+	walVersion        = 1
+	walHeaderSize     = 8
+	walPayloadSize    = 34
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walWriter appends records to a log file and syncs every record to stable storage.
+// It is safe for concurrent use.
+type walWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  []byte
+}
+
+// createWAL creates an empty log file for appending.
+func createWAL(filename string) (*walWriter, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, walFileHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], walMagic)
+	binary.LittleEndian.PutUint32(header[4:], walVersion)
+	if _, err = file.Write(header); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &walWriter{file: file}, nil
+}
+
+func (w *walWriter) Append(key Key, message Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	buf := append(w.buf[:0], make([]byte, walHeaderSize+walPayloadSize)...)
+	buf = appendCorrection(buf, message)
+	buf = append(buf, message.value...)
+	w.buf = buf
+	payload := buf[walHeaderSize:]
+	binary.LittleEndian.PutUint64(payload[0:], uint64(key))
+	binary.LittleEndian.PutUint64(payload[8:], uint64(message.sequenceNumber))
+	binary.LittleEndian.PutUint64(payload[16:], uint64(message.creationTime))
+	binary.LittleEndian.PutUint64(payload[24:], uint64(message.arrivalTime))
+	payload[32] = byte(message.kind)
+	payload[33] = byte(message.valueType)
+	binary.LittleEndian.PutUint32(buf[0:], crc32.Checksum(payload, crcTable))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(payload)))
+
+	if _, err := w.file.Write(buf); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *walWriter) Close() error {
+	return w.file.Close()
+}
+
+// replayWAL reads the log file and calls fn for every intact record in order.
+// Reading stops at the first torn or corrupted record, which can only be the tail written during a crash.
+// A missing file is treated as an empty log, and so is a log whose file header was torn. Replaying a log of
+// another version fails with UnsupportedFormatVersion.
+func replayWAL(filename string, fn func(key Key, message Message) error) error {
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, walHeaderSize)
+	if _, err = io.ReadFull(file, header[:walFileHeaderSize]); err != nil {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != walMagic {
+		return CorruptedFile{filename, "bad magic number"}
+	}
+	if version := binary.LittleEndian.Uint32(header[4:]); version != walVersion {
+		return UnsupportedFormatVersion{filename, version}
+	}
+
+	var payload []byte
+	for {
+		if _, err = io.ReadFull(file, header); err != nil {
+			break
+		}
+		length := binary.LittleEndian.Uint32(header[4:])
+		if length < walPayloadSize {
+			break
+		}
+		if uint32(cap(payload)) < length {
+			payload = make([]byte, length)
+		}
+		payload = payload[:length]
+		if _, err = io.ReadFull(file, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[0:]) {
+			break
+		}
+		message := Message{
+			sequenceNumber: SequenceNumber(binary.LittleEndian.Uint64(payload[8:])),
+			creationTime:   ValidTime(binary.LittleEndian.Uint64(payload[16:])),
+			arrivalTime:    ValidTime(binary.LittleEndian.Uint64(payload[24:])),
+			kind:           messageKind(payload[32]),
+			valueType:      ValueType(payload[33]),
+		}
+		d := decoder{buf: payload[walPayloadSize:]}
+		d.correction(&message)
+		if d.err != nil {
+			return CorruptedFile{filename, "record: " + d.err.Error()}
+		}
+		message.value = string(d.buf)
+		if err = fn(Key(binary.LittleEndian.Uint64(payload[0:])), message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
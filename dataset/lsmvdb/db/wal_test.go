@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_ReplayWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j < 10; j++ {
+		if err := db.Put(Key(1), SequenceNumber(j), ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	message, status, nextSequence, err := db.Get(Key(1), ValidTime(45))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.SequenceNumber() != 4 || message.Value() != "value 4" || status != OK || nextSequence != 5 {
+		t.Fatalf("unexpected result after replay: %s %s %d", message, status, nextSequence)
+	}
+}
+
+func TestReplayWAL_TornTail(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "000001.log")
+	w, err := createWAL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 1; j <= 3; j++ {
+		if err := w.Append(Key(j), Message{creationTime: ValidTime(j), sequenceNumber: SequenceNumber(j), value: "value"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	// simulate a crash in the middle of the last record
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filename, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err = replayWAL(filename, func(Key, Message) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("replayed %d records, want 2", count)
+	}
+}
+
+func TestReplayWAL_UnsupportedVersion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "000001.log")
+	w, err := createWAL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[4]++ // the version in the file header
+	if err := os.WriteFile(filename, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = replayWAL(filename, func(Key, Message) error { return nil })
+	if _, ok := err.(UnsupportedFormatVersion); !ok {
+		t.Fatalf("replay of a log of another version returned %v", err)
+	}
+}
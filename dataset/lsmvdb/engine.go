@@ -0,0 +1,42 @@
+// Package lsmvdb defines the interface shared by the multi-version storage engines of this module.
+package lsmvdb
+
+import (
+	"github.com/atlasmir/golsmvdb/lsmvdb/db"
+	"github.com/atlasmir/golsmvdb/lsmvdb/memdb"
+)
+
+// Engine is a multi-version key-value store. Every engine classifies the versions returned by Get
+// as OK, ODV, HOLE or NOTFOUND following the contract of db.DB.
+type Engine interface {
+	// Put stores a version of key. The version updates the pending queries of the engine.
+	Put(key db.Key, sequenceNumber db.SequenceNumber, creationTime db.ValidTime, value string) error
+	// PutAt is like Put, but records the time the version arrived. Put records an arrival time of 0.
+	PutAt(key db.Key, sequenceNumber db.SequenceNumber, creationTime, arrivalTime db.ValidTime, value string) error
+	// PutValue is like PutAt, but stores a typed value.
+	PutValue(key db.Key, sequenceNumber db.SequenceNumber, creationTime, arrivalTime db.ValidTime, value db.Value) error
+	// Get returns the version of key valid at time, its status and the sequence number of the next version.
+	Get(key db.Key, time db.ValidTime) (message db.Message, status db.Status, nextSequence db.SequenceNumber, err error)
+	// GetAsOf returns what Get would have returned if executed at arrivalTime, considering only the versions
+	// that arrived by then.
+	GetAsOf(key db.Key, time, arrivalTime db.ValidTime) (message db.Message, status db.Status, nextSequence db.SequenceNumber, err error)
+	// Query reads the versions of keys valid at requestTime, and returns the query holding them. A query whose
+	// results are not final is kept in the query pool of the engine, and the versions written afterwards refine
+	// it until they are, or until its deadline passes. Done is closed when the query completes.
+	Query(keys []db.Key, requestTime db.ValidTime, opts *db.QueryOptions) (*db.Query, error)
+	// Advance moves the clock of the engine to clock, and expires the queries whose deadline has passed. Every write
+	// advances it to the creation time or the arrival time of its version, whichever is later.
+	Advance(clock db.ValidTime)
+	// ForgetQueries drops the completed queries that arrived before arrivalTime. The engine keeps the completed
+	// queries so that the corrections of the versions they returned flag them as retracted.
+	ForgetQueries(arrivalTime db.ValidTime)
+	// Close releases the resources of the engine. The engine must not be used afterwards.
+	Close() error
+	// SetSensors passes the sensor properties used by the query pool to the engine.
+	SetSensors(sensors map[int][]int)
+}
+
+var (
+	_ Engine = (*db.DB)(nil)
+	_ Engine = (*memdb.DB)(nil)
+)
@@ -1,5 +1,3 @@
 module github.com/atlasmir/golsmvdb/lsmvdb
 
 go 1.19
-
-require github.com/MauriceGit/skiplist v0.0.0-20211105230623-77f5c8d3e145 // indirect
@@ -1 +1,362 @@
+// Package memdb implements an in-memory multi-version store. It follows the Put/Get contract of db.DB,
+// but holds every version in memory and never touches a disk.
 package memdb
+
+import (
+	"math"
+	"sync"
+
+	"github.com/atlasmir/golsmvdb/lsmvdb/db"
+)
+
+// Options controls the behaviour of a DB. The zero value of a field selects its default.
+type Options struct {
+	// MaxVersionsPerKey is the number of the newest versions of a key the store keeps. 0 keeps every version.
+	MaxVersionsPerKey int
+	// QueryDeadline is the time a query issued by Query waits for its final results after its arrival,
+	// unless db.QueryOptions.Deadline overrides it. The default lets it wait until they are final.
+	QueryDeadline db.ValidTime
+	// QueryCorrectness is the probability of temporal correctness at which a query issued by Query completes
+	// before its results are final, unless db.QueryOptions.Correctness overrides it. The default is 1.
+	QueryCorrectness float64
+	// OutOfOrderPolicy selects how a version whose sequence number contradicts its creation time is handled,
+	// as by db.Options. The default rejects such writes.
+	OutOfOrderPolicy db.OutOfOrderPolicy
+	// Retention is the valid-time span of history kept for every key, unless overridden by SetRetention,
+	// as by db.Options. 0 keeps every version.
+	Retention db.ValidTime
+}
+
+// DB is an in-memory multi-version store. It is safe for concurrent use.
+type DB struct {
+	mu   sync.RWMutex
+	opts Options
+	// data holds the versions of each key ordered by creation time and sequence number.
+	data map[db.Key]*db.SkipList[db.Message]
+	// quarantined holds the error reported for the keys quarantined by the QuarantineOutOfOrder policy
+	quarantined map[db.Key]error
+	retention   map[db.Key]db.ValidTime // the retention of the keys overriding opts.Retention
+	latest      db.ValidTime            // the latest creation time written
+
+	// queryMu guards queries and clock. It is acquired before mu, since a query reads the versions it is issued for.
+	queryMu sync.Mutex
+	queries *db.QueryPool // the pending queries issued by Query
+	clock   db.ValidTime  // the latest creation or arrival time written, or passed to Advance
+
+	// helper fields for experiments
+	sensors map[int][]int // stores the sensor properties
+}
+
+// New returns an empty store. A nil opts selects the default options.
+func New(opts *Options) *DB {
+	m := &DB{data: make(map[db.Key]*db.SkipList[db.Message]), quarantined: make(map[db.Key]error),
+		retention: make(map[db.Key]db.ValidTime), queries: db.NewQueryPool()}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.QueryDeadline == 0 {
+		m.opts.QueryDeadline = math.MaxUint64
+	}
+	if m.opts.QueryCorrectness <= 0 {
+		m.opts.QueryCorrectness = 1
+	}
+	return m
+}
+
+// Put stores a version of key. Repeated and out-of-order versions are handled as by db.DB.Put (see db.Admit).
+// If the key holds more than MaxVersionsPerKey versions afterwards, the oldest ones are dropped, and so are
+// the versions whose validity ends at or before the retention horizon of the key.
+// The version updates the pending queries issued by Query.
+func (m *DB) Put(key db.Key, sequenceNumber db.SequenceNumber, creationTime db.ValidTime, value string) error {
+	return m.PutAt(key, sequenceNumber, creationTime, 0, value)
+}
+
+// PutAt is like Put, but records the time the version arrived at the store. See db.DB.PutAt.
+func (m *DB) PutAt(key db.Key, sequenceNumber db.SequenceNumber, creationTime, arrivalTime db.ValidTime, value string) error {
+	return m.PutValue(key, sequenceNumber, creationTime, arrivalTime, db.StringValue(value))
+}
+
+// PutValue is like PutAt, but stores a typed value.
+func (m *DB) PutValue(key db.Key, sequenceNumber db.SequenceNumber, creationTime, arrivalTime db.ValidTime, value db.Value) error {
+	message, err := m.insert(key, sequenceNumber, creationTime, arrivalTime, value)
+	if err != nil || message == nil {
+		return err
+	}
+	m.updateQueries(key, message)
+	return nil
+}
+
+// insert admits a version of key and stores it, and returns the stored version, or nil for a duplicate.
+func (m *DB) insert(key db.Key, sequenceNumber db.SequenceNumber, creationTime, arrivalTime db.ValidTime, value db.Value) (*db.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list, exist := m.data[key]
+	if !exist {
+		list = db.NewSkipList(compareVersions)
+		m.data[key] = list
+	}
+	prev, next := neighbours(list, *db.NewMessage(creationTime, sequenceNumber, ""))
+	versions := func() []db.Message {
+		versions := make([]db.Message, 0, list.Len())
+		for elem := list.First(); elem != nil; elem = elem.Next() {
+			versions = append(versions, elem.Value())
+		}
+		return versions
+	}
+	creationTime, duplicate, err := db.Admit(key, prev, next, versions, sequenceNumber, creationTime, value, m.opts.OutOfOrderPolicy)
+	if _, ok := err.(db.KeyQuarantined); ok {
+		m.quarantined[key] = err
+		err = nil
+	}
+	if err != nil || duplicate {
+		return nil, err
+	}
+	message := db.NewValueMessage(creationTime, sequenceNumber, arrivalTime, value)
+	list.Insert(*message)
+	if creationTime > m.latest {
+		m.latest = creationTime
+	}
+	for horizon := m.horizon(key); list.Len() > 1 && list.First().Next().Value().CreationTime() <= horizon; {
+		list.Delete(list.First().Value())
+	}
+	// dropping the oldest versions never drops the successor of a kept version, so the kept ones are classified as before
+	for m.opts.MaxVersionsPerKey > 0 && list.Len() > m.opts.MaxVersionsPerKey {
+		list.Delete(list.First().Value())
+	}
+	return message, nil
+}
+
+// Get function returns the message body, the status and the sequence number of the next message to a query.
+// The status is classified as by db.DB.Get.
+func (m *DB) Get(key db.Key, time db.ValidTime) (message db.Message, status db.Status, nextSequence db.SequenceNumber, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err, quarantined := m.quarantined[key]; quarantined {
+		return db.Message{}, db.Status(db.QUARANTINED), 0, err
+	}
+	if time < m.horizon(key) {
+		return db.Message{}, db.Status(db.EXPIRED), 0, nil
+	}
+	list, exist := m.data[key]
+	if !exist {
+		return db.Resolve(nil, nil)
+	}
+	// the first version created after time
+	elem := list.Seek(*db.NewMessage(time, math.MaxUint64, ""))
+	if elem != nil && elem.Value().CreationTime() == time {
+		elem = elem.Next()
+	}
+	var found, next *db.Message
+	var prev *db.SkipListNode[db.Message]
+	if elem == nil {
+		prev = list.Last()
+	} else {
+		prev = elem.Prev()
+		successor := elem.Value()
+		next = &successor
+	}
+	if prev != nil {
+		version := prev.Value()
+		found = &version
+	}
+	return db.Resolve(found, next)
+}
+
+// GetAsOf returns what Get(key, time) would have returned if executed at arrivalTime. See db.DB.GetAsOf.
+func (m *DB) GetAsOf(key db.Key, time, arrivalTime db.ValidTime) (message db.Message, status db.Status, nextSequence db.SequenceNumber, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err, quarantined := m.quarantined[key]; quarantined {
+		return db.Message{}, db.Status(db.QUARANTINED), 0, err
+	}
+	if time < m.horizon(key) {
+		return db.Message{}, db.Status(db.EXPIRED), 0, nil
+	}
+	var found, next *db.Message
+	if list, exist := m.data[key]; exist {
+		for elem := list.First(); elem != nil; elem = elem.Next() {
+			version := elem.Value()
+			if version.ArrivalTime() > arrivalTime {
+				continue
+			}
+			if version.CreationTime() > time {
+				next = &version
+				break
+			}
+			found = &version
+		}
+	}
+	return db.Resolve(found, next)
+}
+
+// Query reads the versions of keys valid at requestTime, and returns the query holding them. The query is kept in
+// the pool of the store until its results are final, as in db.DB.
+func (m *DB) Query(keys []db.Key, requestTime db.ValidTime, opts *db.QueryOptions) (*db.Query, error) {
+	if opts == nil {
+		opts = &db.QueryOptions{}
+	}
+	m.queryMu.Lock()
+	defer m.queryMu.Unlock()
+	arrivalTime := opts.ArrivalTime
+	if arrivalTime == 0 {
+		arrivalTime = m.clock
+	}
+	deadline, ck := opts.Deadline, opts.Correctness
+	if deadline == 0 {
+		deadline = m.opts.QueryDeadline
+	}
+	if ck <= 0 {
+		ck = m.opts.QueryCorrectness
+	}
+	unique := make([]db.Key, 0, len(keys))
+	seen := make(map[db.Key]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	query := db.NewQuery(arrivalTime, requestTime, len(unique), deadline, ck)
+	if _, err := m.queries.Execute(query, unique, m.Get); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// updateQueries updates the pending queries with a version of key that has just been written. The creation time and
+// the arrival time of the version advance the clock, as in db.DB.
+func (m *DB) updateQueries(key db.Key, message *db.Message) {
+	m.queryMu.Lock()
+	defer m.queryMu.Unlock()
+	if message.CreationTime() > m.clock {
+		m.clock = message.CreationTime()
+	}
+	if message.ArrivalTime() > m.clock {
+		m.clock = message.ArrivalTime()
+	}
+	m.queries.Advance(m.clock)
+	m.queries.Update(m.clock, key, message)
+}
+
+// Advance moves the clock of the store to clock, unless it is past it already, and expires the queries issued by
+// Query whose deadline has passed, as in db.DB.
+func (m *DB) Advance(clock db.ValidTime) {
+	m.queryMu.Lock()
+	defer m.queryMu.Unlock()
+	if clock > m.clock {
+		m.clock = clock
+	}
+	m.queries.Advance(m.clock)
+}
+
+// ForgetQueries drops the completed queries issued by Query that arrived before arrivalTime, as in db.DB.
+func (m *DB) ForgetQueries(arrivalTime db.ValidTime) {
+	m.queryMu.Lock()
+	defer m.queryMu.Unlock()
+	m.queries.Forget(arrivalTime)
+}
+
+// SetRetention sets the valid-time span of history kept for key, overriding opts.Retention. A retention of 0 restores
+// opts.Retention. The retention horizon of a key is the latest creation time written to the store minus its
+// retention, and reads below it report EXPIRED, as in db.DB.
+func (m *DB) SetRetention(key db.Key, retention db.ValidTime) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if retention == 0 {
+		delete(m.retention, key)
+	} else {
+		m.retention[key] = retention
+	}
+	return nil
+}
+
+// Horizon returns the current retention horizon of key.
+func (m *DB) Horizon(key db.Key) db.ValidTime {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.horizon(key)
+}
+
+// horizon implements Horizon for a caller holding mu.
+func (m *DB) horizon(key db.Key) db.ValidTime {
+	retention, ok := m.retention[key]
+	if !ok {
+		retention = m.opts.Retention
+	}
+	if retention == 0 || m.latest <= retention {
+		return 0
+	}
+	return m.latest - retention
+}
+
+// Quarantined reports whether key has been quarantined by the QuarantineOutOfOrder policy.
+func (m *DB) Quarantined(key db.Key) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, quarantined := m.quarantined[key]
+	return quarantined
+}
+
+// Release lifts the quarantine of key. Its versions are read as they are stored again.
+func (m *DB) Release(key db.Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.quarantined, key)
+}
+
+// Close releases the versions held by the store. The store must not be used afterwards.
+func (m *DB) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = nil
+	return nil
+}
+
+// SetSensors passes the sensor properties to the store and to the pool of its queries.
+func (m *DB) SetSensors(sensors map[int][]int) {
+	m.queryMu.Lock()
+	m.queries.SetSensors(sensors)
+	m.queryMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sensors = sensors
+}
+
+// neighbours returns the versions in list preceding and succeeding probe, or the version equal to probe and its
+// successor, as db.Admit takes them.
+func neighbours(list *db.SkipList[db.Message], probe db.Message) (prev, next *db.Message) {
+	elem := list.Seek(probe)
+	var before *db.SkipListNode[db.Message]
+	if elem != nil && compareVersions(elem.Value(), probe) == 0 {
+		before, elem = elem, elem.Next()
+	} else if elem != nil {
+		before = elem.Prev()
+	} else {
+		before = list.Last()
+	}
+	if before != nil {
+		version := before.Value()
+		prev = &version
+	}
+	if elem != nil {
+		version := elem.Value()
+		next = &version
+	}
+	return prev, next
+}
+
+// compareVersions orders two versions of the same key by creation time, then by sequence number.
+func compareVersions(a, b db.Message) int {
+	switch {
+	case a.CreationTime() < b.CreationTime():
+		return -1
+	case a.CreationTime() > b.CreationTime():
+		return 1
+	case a.SequenceNumber() < b.SequenceNumber():
+		return -1
+	case a.SequenceNumber() > b.SequenceNumber():
+		return 1
+	default:
+		return 0
+	}
+}
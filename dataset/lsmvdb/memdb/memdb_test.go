@@ -0,0 +1,247 @@
+package memdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/atlasmir/golsmvdb/lsmvdb/db"
+)
+
+func TestDB_MatchesDB(t *testing.T) {
+	m := New(nil)
+	defer m.Close()
+	d, err := db.Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for k := 1; k < 10; k++ {
+		for j := 1; j < 100; j++ {
+			if j%7 == 0 {
+				continue
+			}
+			if err := m.Put(db.Key(k), db.SequenceNumber(j), db.ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Put(db.Key(k), db.SequenceNumber(j), db.ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for k := 0; k < 11; k++ {
+		for time := 0; time < 1010; time += 5 {
+			m1, s1, n1, _ := d.Get(db.Key(k), db.ValidTime(time))
+			m2, s2, n2, _ := m.Get(db.Key(k), db.ValidTime(time))
+			if m1 != m2 || s1 != s2 || n1 != n2 {
+				t.Fatalf("Get(%d, %d) = %s %s %d, want %s %s %d", k, time, m2, s2, n2, m1, s1, n1)
+			}
+		}
+	}
+}
+
+func TestDB_MaxVersionsPerKey(t *testing.T) {
+	m := New(&Options{MaxVersionsPerKey: 3})
+	defer m.Close()
+	for _, seq := range []int{1, 2, 3, 5, 6, 4} {
+		if err := m.Put(1, db.SequenceNumber(seq), db.ValidTime(seq*10), fmt.Sprintf("value %d", seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		time   db.ValidTime
+		seq    db.SequenceNumber
+		status db.Status
+		next   db.SequenceNumber
+	}{
+		{35, 0, db.NOTFOUND, 4},
+		{40, 4, db.OK, 5},
+		{55, 5, db.OK, 6},
+		{60, 6, db.ODV, 0},
+	} {
+		message, status, next, err := m.Get(1, c.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.SequenceNumber() != c.seq || status != c.status || next != c.next {
+			t.Fatalf("Get(1, %d) = %s %s %d, want version %d %s %d", c.time, message, status, next, c.seq, c.status, c.next)
+		}
+	}
+}
+
+func TestDB_SequenceOutOfOrder(t *testing.T) {
+	m := New(nil)
+	defer m.Close()
+	if err := m.Put(1, 5, 10, "value 5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put(1, 3, 20, "value 3"); !errors.As(err, &db.SequenceOutOfOrder{}) {
+		t.Fatalf("Put(1, 3, 20) = %v, want SequenceOutOfOrder", err)
+	}
+	if _, status, _, _ := m.Get(1, 25); status != db.ODV {
+		t.Fatalf("Get(1, 25) = %s, want ODV", status)
+	}
+}
+
+func TestDB_OutOfOrderMatchesDB(t *testing.T) {
+	writes := []struct {
+		seq   db.SequenceNumber
+		time  db.ValidTime
+		value string
+	}{
+		{1, 10, "value 1"}, {3, 30, "value 3"}, {5, 50, "value 5"},
+		{2, 40, "value 2"}, {4, 5, "value 4"}, // out of order
+		{2, 40, "value 2"}, {3, 30, "value 3"}, // retries
+		{3, 45, "other"}, {5, 50, "other"}, // conflicts
+	}
+	for _, policy := range []db.OutOfOrderPolicy{db.RejectOutOfOrder, db.ResortOutOfOrder, db.QuarantineOutOfOrder} {
+		m := New(&Options{OutOfOrderPolicy: policy})
+		d, err := db.Open("", &db.Options{OutOfOrderPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range writes {
+			err1 := d.Put(1, w.seq, w.time, w.value)
+			err2 := m.Put(1, w.seq, w.time, w.value)
+			if fmt.Sprint(err1) != fmt.Sprint(err2) {
+				t.Fatalf("policy %d: Put(1, %d, %d) = %v, want %v", policy, w.seq, w.time, err2, err1)
+			}
+		}
+		if d.Quarantined(1) != m.Quarantined(1) {
+			t.Fatalf("policy %d: quarantined %t, want %t", policy, m.Quarantined(1), d.Quarantined(1))
+		}
+		d.Release(1)
+		m.Release(1)
+		for time := db.ValidTime(0); time <= 60; time += 5 {
+			m1, s1, n1, err1 := d.Get(1, time)
+			m2, s2, n2, err2 := m.Get(1, time)
+			if m1 != m2 || s1 != s2 || n1 != n2 || fmt.Sprint(err1) != fmt.Sprint(err2) {
+				t.Fatalf("policy %d: Get(1, %d) = %s %s %d %v, want %s %s %d %v", policy, time, m2, s2, n2, err2, m1, s1, n1, err1)
+			}
+		}
+		d.Close()
+		m.Close()
+	}
+}
+
+func TestDB_QueryMatchesDB(t *testing.T) {
+	m := New(&Options{QueryDeadline: 50})
+	defer m.Close()
+	d, err := db.Open("", &db.Options{QueryDeadline: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	done := func(query *db.Query) bool {
+		select {
+		case <-query.Done():
+			return true
+		default:
+			return false
+		}
+	}
+	for _, e := range []interface {
+		Put(key db.Key, sequenceNumber db.SequenceNumber, creationTime db.ValidTime, value string) error
+		Query(keys []db.Key, requestTime db.ValidTime, opts *db.QueryOptions) (*db.Query, error)
+	}{m, d} {
+		for _, key := range []db.Key{1, 2} {
+			if err := e.Put(key, 1, 10, "value 1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pending, err := e.Query([]db.Key{1, 2}, 15, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Put(1, 2, 20, "value 2"); err != nil {
+			t.Fatal(err)
+		}
+		if done(pending) {
+			t.Fatalf("%T: query completed with an ODV result", e)
+		}
+		// the query expires before the successor of key 2 is written
+		if err := e.Put(2, 3, 70, "value 3"); err != nil {
+			t.Fatal(err)
+		}
+		answered, err := e.Query([]db.Key{1, 1}, 15, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !done(pending) || !done(answered) || answered.ArrivalTime() != 70 {
+			t.Fatalf("%T: done %t and %t, second query arrived at %d", e, done(pending), done(answered), answered.ArrivalTime())
+		}
+		if pending.Result(1).Message().SequenceNumber() != 1 || pending.Result(2).Message().SequenceNumber() != 1 {
+			t.Fatalf("%T: results %s and %s, want version 1 of both keys", e, pending.Result(1).Message(), pending.Result(2).Message())
+		}
+	}
+}
+
+func TestDB_GetAsOf(t *testing.T) {
+	m := New(nil)
+	defer m.Close()
+	if err := m.PutAt(1, 1, 10, 15, "value 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutAt(1, 2, 20, 50, "value 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutAt(1, 3, 30, 35, "value 3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		time, arrival db.ValidTime
+		seq           db.SequenceNumber
+		status        db.Status
+	}{
+		{25, 10, 0, db.NOTFOUND},
+		{25, 40, 1, db.HOLE},
+		{25, 50, 2, db.OK},
+		{35, 40, 3, db.ODV},
+	} {
+		message, status, _, err := m.GetAsOf(1, c.time, c.arrival)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.SequenceNumber() != c.seq || status != c.status {
+			t.Fatalf("GetAsOf(1, %d, %d) = %s %s, want version %d %s", c.time, c.arrival, message, status, c.seq, c.status)
+		}
+	}
+}
+
+func TestDB_RetentionMatchesDB(t *testing.T) {
+	m := New(&Options{Retention: 200})
+	defer m.Close()
+	d, err := db.Open("", &db.Options{Retention: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	m.SetRetention(2, 50)
+	d.SetRetention(2, 50)
+	for j := 1; j <= 100; j++ {
+		for k := 1; k <= 2; k++ {
+			if err := m.Put(db.Key(k), db.SequenceNumber(j), db.ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Put(db.Key(k), db.SequenceNumber(j), db.ValidTime(j*10), fmt.Sprintf("value %d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for k := db.Key(1); k <= 2; k++ {
+		if m.Horizon(k) != d.Horizon(k) {
+			t.Fatalf("Horizon(%d) = %d, want %d", k, m.Horizon(k), d.Horizon(k))
+		}
+		for time := db.ValidTime(700); time <= 1000; time += 5 {
+			m1, s1, n1, _ := d.Get(k, time)
+			m2, s2, n2, _ := m.Get(k, time)
+			if m1 != m2 || s1 != s2 || n1 != n2 {
+				t.Fatalf("Get(%d, %d) = %s %s %d, want %s %s %d", k, time, m2, s2, n2, m1, s1, n1)
+			}
+		}
+	}
+	// the versions valid only below the horizon are dropped
+	if n := m.data[2].Len(); n != 6 {
+		t.Fatalf("key 2 holds %d versions, want 6", n)
+	}
+}